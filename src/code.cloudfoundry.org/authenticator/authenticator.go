@@ -4,36 +4,64 @@ import (
 	"crypto/tls"
 	"encoding/json"
 	"errors"
-	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"strings"
+	"sync"
 	"time"
 )
 
+const (
+	defaultRefreshMargin      = time.Minute
+	defaultMinRefreshInterval = 5 * time.Second
+)
+
+// Grant types supported by the Authenticator.
+const (
+	grantClientCredentials = "client_credentials"
+	grantPassword          = "password"
+	grantRefreshToken      = "refresh_token"
+	grantStatic            = "static"
+)
+
+// Authenticator fetches tokens from UAA. Tokens are cached until shortly
+// before they expire, and concurrent callers share a single request to UAA.
+// By default tokens are requested with the client_credentials grant; see
+// the With...Grant options for the alternatives.
 type Authenticator struct {
-	clientID     string
-	clientSecret string
-	uaaAddr      string
-	httpClient   httpClient
+	clientID      string
+	clientSecret  string
+	uaaAddr       string
+	httpClient    httpClient
+	refreshMargin time.Duration
+
+	// minRefreshInterval is how long after a token is fetched requests to
+	// refresh it return it instead of fetching another.
+	minRefreshInterval time.Duration
+
+	grantType    string
+	username     string
+	password     string
+	refreshToken string
+	staticToken  string
+
+	mu        sync.Mutex
+	token     string
+	fetchedAt time.Time
+	refreshAt time.Time
+	expiresAt time.Time
 }
 
 func New(id, secret, uaaAddr string, opts ...authenticatorOpt) *Authenticator {
-	httpClient := &http.Client{
-		Timeout: 30 * time.Second,
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{
-				InsecureSkipVerify: true,
-			},
-			DisableKeepAlives: true,
-		},
-	}
-
 	a := &Authenticator{
-		clientID:     id,
-		clientSecret: secret,
-		uaaAddr:      uaaAddr,
-		httpClient:   httpClient,
+		clientID:           id,
+		clientSecret:       secret,
+		uaaAddr:            uaaAddr,
+		httpClient:         newHTTPClient(&tls.Config{}),
+		refreshMargin:      defaultRefreshMargin,
+		minRefreshInterval: defaultMinRefreshInterval,
+		grantType:          grantClientCredentials,
 	}
 
 	for _, o := range opts {
@@ -43,18 +71,68 @@ func New(id, secret, uaaAddr string, opts ...authenticatorOpt) *Authenticator {
 	return a
 }
 
+// Token returns a cached token, fetching a new one from UAA when there is
+// none or the cached token is within the refresh margin of its expiry. If
+// the refresh fails while the cached token is still valid, the cached token
+// is returned.
 func (a *Authenticator) Token() (string, error) {
-	response, err := a.httpClient.PostForm(a.uaaAddr+"/oauth/token", url.Values{
-		"response_type": {"token"},
-		"grant_type":    {"client_credentials"},
-		"client_id":     {a.clientID},
-		"client_secret": {a.clientSecret},
-	})
+	if a.grantType == grantStatic {
+		return a.staticToken, nil
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := time.Now()
+	if a.token != "" && now.Before(a.refreshAt) {
+		return a.token, nil
+	}
+
+	token, err := a.fetch()
 	if err != nil {
+		if a.token != "" && now.Before(a.expiresAt) {
+			return a.token, nil
+		}
+
 		return "", err
 	}
+
+	return token, nil
+}
+
+// RefreshAuthToken fetches a new token, for when a server rejects the
+// cached one. It satisfies the noaa consumer's TokenRefresher. Callers that
+// are rejected together share a single request to UAA: a token fetched
+// within the minimum refresh interval is returned as it is. If the refresh
+// fails the error is returned rather than the rejected token.
+func (a *Authenticator) RefreshAuthToken() (string, error) {
+	if a.grantType == grantStatic {
+		return a.staticToken, nil
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := time.Now()
+	if a.token != "" && now.Sub(a.fetchedAt) < a.minRefreshInterval {
+		return a.token, nil
+	}
+
+	return a.fetch()
+}
+
+// fetch requests a token from UAA and caches it. It must be called with mu
+// held. Tokens without an expires_in are not cached.
+func (a *Authenticator) fetch() (string, error) {
+	requestedAt := time.Now()
+	response, err := a.httpClient.PostForm(a.uaaAddr+"/oauth/token", a.form())
+	if err != nil {
+		return "", &TransientError{Err: err}
+	}
 	if response.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("Expected 200 status code from /oauth/token, got %d", response.StatusCode)
+		body, _ := ioutil.ReadAll(response.Body)
+		response.Body.Close()
+		return "", statusError(response.StatusCode, body)
 	}
 
 	body, err := ioutil.ReadAll(response.Body)
@@ -79,13 +157,64 @@ func (a *Authenticator) Token() (string, error) {
 		return "", errors.New("access_token on UAA oauth response not a string")
 	}
 
-	return "bearer " + accessToken, nil
+	token := "bearer " + accessToken
+
+	// UAA may issue a new refresh token with every access token.
+	if refreshToken, ok := oauthResponse["refresh_token"].(string); ok && a.grantType == grantRefreshToken {
+		a.refreshToken = refreshToken
+	}
+
+	expiresIn, _ := oauthResponse["expires_in"].(float64)
+	if expiresIn > 0 {
+		lifetime := time.Duration(expiresIn) * time.Second
+		margin := a.refreshMargin
+		if margin > lifetime/2 {
+			margin = lifetime / 2
+		}
+
+		a.token = token
+		a.fetchedAt = requestedAt
+		a.expiresAt = requestedAt.Add(lifetime)
+		a.refreshAt = a.expiresAt.Add(-margin)
+	}
+
+	return token, nil
+}
+
+// form returns the body of a token request for the configured grant.
+func (a *Authenticator) form() url.Values {
+	form := url.Values{
+		"response_type": {"token"},
+		"grant_type":    {a.grantType},
+		"client_id":     {a.clientID},
+		"client_secret": {a.clientSecret},
+	}
+
+	switch a.grantType {
+	case grantPassword:
+		form.Set("username", a.username)
+		form.Set("password", a.password)
+	case grantRefreshToken:
+		form.Set("refresh_token", a.refreshToken)
+	}
+
+	return form
 }
 
 type httpClient interface {
 	PostForm(string, url.Values) (*http.Response, error)
 }
 
+func newHTTPClient(tlsConfig *tls.Config) *http.Client {
+	return &http.Client{
+		Timeout: 30 * time.Second,
+		Transport: &http.Transport{
+			TLSClientConfig:   tlsConfig,
+			DisableKeepAlives: true,
+		},
+	}
+}
+
 type authenticatorOpt func(*Authenticator)
 
 func WithHTTPClient(c httpClient) authenticatorOpt {
@@ -93,3 +222,69 @@ func WithHTTPClient(c httpClient) authenticatorOpt {
 		a.httpClient = c
 	}
 }
+
+// WithTLSConfig connects to UAA with the given TLS config. By default the
+// UAA certificate is verified against the system pool.
+func WithTLSConfig(tlsConfig *tls.Config) authenticatorOpt {
+	return func(a *Authenticator) {
+		a.httpClient = newHTTPClient(tlsConfig)
+	}
+}
+
+// WithClientCredentialsGrant requests tokens for the client given to New.
+// This is the default.
+func WithClientCredentialsGrant() authenticatorOpt {
+	return func(a *Authenticator) {
+		a.grantType = grantClientCredentials
+	}
+}
+
+// WithPasswordGrant requests tokens for a user with the password grant. The
+// client given to New is used to make the request; UAA's "cf" client with
+// an empty secret is the usual choice.
+func WithPasswordGrant(username, password string) authenticatorOpt {
+	return func(a *Authenticator) {
+		a.grantType = grantPassword
+		a.username = username
+		a.password = password
+	}
+}
+
+// WithRefreshTokenGrant requests tokens with the refresh_token grant,
+// starting from the given refresh token. Refresh tokens issued by UAA
+// replace it as they are received.
+func WithRefreshTokenGrant(refreshToken string) authenticatorOpt {
+	return func(a *Authenticator) {
+		a.grantType = grantRefreshToken
+		a.refreshToken = refreshToken
+	}
+}
+
+// WithStaticToken returns the given bearer token instead of requesting one
+// from UAA. The token is never refreshed.
+func WithStaticToken(token string) authenticatorOpt {
+	return func(a *Authenticator) {
+		a.grantType = grantStatic
+		a.staticToken = token
+		if !strings.HasPrefix(strings.ToLower(token), "bearer ") {
+			a.staticToken = "bearer " + token
+		}
+	}
+}
+
+// WithRefreshMargin sets how long before expiry a cached token is
+// refreshed. The margin is capped at half the lifetime of the token.
+func WithRefreshMargin(d time.Duration) authenticatorOpt {
+	return func(a *Authenticator) {
+		a.refreshMargin = d
+	}
+}
+
+// WithMinRefreshInterval sets how long after a token is fetched
+// RefreshAuthToken returns it instead of fetching another. The default is
+// five seconds.
+func WithMinRefreshInterval(d time.Duration) authenticatorOpt {
+	return func(a *Authenticator) {
+		a.minRefreshInterval = d
+	}
+}
@@ -1,9 +1,12 @@
 package authenticator_test
 
 import (
+	"errors"
 	"net/http"
 	"net/url"
 	"strings"
+	"sync"
+	"time"
 
 	"code.cloudfoundry.org/authenticator"
 
@@ -33,29 +36,343 @@ var _ = Describe("Authenticator", func() {
 			"client_secret": {"secret"},
 		}))
 	})
+
+	It("requests a token with the password grant", func() {
+		httpClient := &spyHTTPClient{}
+		a := authenticator.New(
+			"cf",
+			"",
+			"http://localhost/api",
+			authenticator.WithHTTPClient(httpClient),
+			authenticator.WithPasswordGrant("user", "pass"),
+		)
+
+		token, err := a.Token()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(token).To(Equal("bearer my-token"))
+
+		Expect(httpClient.body()).To(Equal(url.Values{
+			"response_type": {"token"},
+			"grant_type":    {"password"},
+			"client_id":     {"cf"},
+			"client_secret": {""},
+			"username":      {"user"},
+			"password":      {"pass"},
+		}))
+	})
+
+	It("requests tokens with the latest refresh token", func() {
+		httpClient := &spyHTTPClient{response: `{"access_token": "my-token", "refresh_token": "new-refresh-token"}`}
+		a := authenticator.New(
+			"cf",
+			"",
+			"http://localhost/api",
+			authenticator.WithHTTPClient(httpClient),
+			authenticator.WithRefreshTokenGrant("refresh-token"),
+		)
+
+		_, err := a.Token()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(httpClient.body().Get("grant_type")).To(Equal("refresh_token"))
+		Expect(httpClient.body().Get("refresh_token")).To(Equal("refresh-token"))
+
+		_, err = a.Token()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(httpClient.body().Get("refresh_token")).To(Equal("new-refresh-token"))
+	})
+
+	It("returns a static token without contacting UAA", func() {
+		httpClient := &spyHTTPClient{}
+		a := authenticator.New(
+			"",
+			"",
+			"",
+			authenticator.WithHTTPClient(httpClient),
+			authenticator.WithStaticToken("my-token"),
+		)
+
+		token, err := a.Token()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(token).To(Equal("bearer my-token"))
+
+		token, err = a.RefreshAuthToken()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(token).To(Equal("bearer my-token"))
+		Expect(httpClient.calls()).To(Equal(0))
+	})
+
+	It("classifies rejected credentials", func() {
+		httpClient := &spyHTTPClient{status: 401, response: `{"error": "unauthorized"}`}
+		a := authenticator.New(
+			"id",
+			"bad-secret",
+			"http://localhost/api",
+			authenticator.WithHTTPClient(httpClient),
+		)
+
+		_, err := a.Token()
+		Expect(authenticator.IsCredentialsError(err)).To(BeTrue())
+		Expect(authenticator.IsTransientError(err)).To(BeFalse())
+	})
+
+	It("classifies server and network failures as transient", func() {
+		httpClient := &spyHTTPClient{status: 503}
+		a := authenticator.New(
+			"id",
+			"secret",
+			"http://localhost/api",
+			authenticator.WithHTTPClient(httpClient),
+		)
+
+		_, err := a.Token()
+		Expect(authenticator.IsTransientError(err)).To(BeTrue())
+
+		httpClient.err = errors.New("connection refused")
+		_, err = a.Token()
+		Expect(authenticator.IsTransientError(err)).To(BeTrue())
+		Expect(authenticator.IsCredentialsError(err)).To(BeFalse())
+	})
+
+	It("caches the token until it is about to expire", func() {
+		httpClient := &spyHTTPClient{response: `{"access_token": "my-token", "expires_in": 3600}`}
+		a := authenticator.New(
+			"id",
+			"secret",
+			"http://localhost/api",
+			authenticator.WithHTTPClient(httpClient),
+		)
+
+		for i := 0; i < 3; i++ {
+			token, err := a.Token()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(token).To(Equal("bearer my-token"))
+		}
+		Expect(httpClient.calls()).To(Equal(1))
+	})
+
+	It("refreshes the token ahead of its expiry", func() {
+		httpClient := &spyHTTPClient{response: `{"access_token": "my-token", "expires_in": 2}`}
+		a := authenticator.New(
+			"id",
+			"secret",
+			"http://localhost/api",
+			authenticator.WithHTTPClient(httpClient),
+			authenticator.WithRefreshMargin(time.Second),
+		)
+
+		_, err := a.Token()
+		Expect(err).ToNot(HaveOccurred())
+		_, err = a.Token()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(httpClient.calls()).To(Equal(1))
+
+		time.Sleep(1100 * time.Millisecond)
+		_, err = a.Token()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(httpClient.calls()).To(Equal(2))
+	})
+
+	It("does not cache tokens without an expiry", func() {
+		httpClient := &spyHTTPClient{}
+		a := authenticator.New(
+			"id",
+			"secret",
+			"http://localhost/api",
+			authenticator.WithHTTPClient(httpClient),
+		)
+
+		_, err := a.Token()
+		Expect(err).ToNot(HaveOccurred())
+		_, err = a.Token()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(httpClient.calls()).To(Equal(2))
+	})
+
+	It("makes a single request for concurrent callers", func() {
+		httpClient := &spyHTTPClient{response: `{"access_token": "my-token", "expires_in": 3600}`}
+		a := authenticator.New(
+			"id",
+			"secret",
+			"http://localhost/api",
+			authenticator.WithHTTPClient(httpClient),
+		)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer GinkgoRecover()
+
+				_, err := a.Token()
+				Expect(err).ToNot(HaveOccurred())
+			}()
+		}
+		wg.Wait()
+
+		Expect(httpClient.calls()).To(Equal(1))
+	})
+
+	It("fetches a new token when asked to refresh", func() {
+		httpClient := &spyHTTPClient{response: `{"access_token": "my-token", "expires_in": 3600}`}
+		a := authenticator.New(
+			"id",
+			"secret",
+			"http://localhost/api",
+			authenticator.WithHTTPClient(httpClient),
+			authenticator.WithMinRefreshInterval(0),
+		)
+
+		_, err := a.Token()
+		Expect(err).ToNot(HaveOccurred())
+
+		token, err := a.RefreshAuthToken()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(token).To(Equal("bearer my-token"))
+		Expect(httpClient.calls()).To(Equal(2))
+
+		_, err = a.Token()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(httpClient.calls()).To(Equal(2))
+	})
+
+	It("does not refresh a token that was just fetched", func() {
+		httpClient := &spyHTTPClient{response: `{"access_token": "my-token", "expires_in": 3600}`}
+		a := authenticator.New(
+			"id",
+			"secret",
+			"http://localhost/api",
+			authenticator.WithHTTPClient(httpClient),
+			authenticator.WithMinRefreshInterval(time.Minute),
+		)
+
+		_, err := a.Token()
+		Expect(err).ToNot(HaveOccurred())
+
+		for i := 0; i < 3; i++ {
+			token, err := a.RefreshAuthToken()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(token).To(Equal("bearer my-token"))
+		}
+		Expect(httpClient.calls()).To(Equal(1))
+	})
+
+	It("refreshes again once the minimum refresh interval has passed", func() {
+		httpClient := &spyHTTPClient{response: `{"access_token": "my-token", "expires_in": 3600}`}
+		a := authenticator.New(
+			"id",
+			"secret",
+			"http://localhost/api",
+			authenticator.WithHTTPClient(httpClient),
+			authenticator.WithMinRefreshInterval(100*time.Millisecond),
+		)
+
+		_, err := a.Token()
+		Expect(err).ToNot(HaveOccurred())
+
+		time.Sleep(150 * time.Millisecond)
+		_, err = a.RefreshAuthToken()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(httpClient.calls()).To(Equal(2))
+	})
+
+	It("returns the error when a refresh fails with a cached token", func() {
+		httpClient := &spyHTTPClient{response: `{"access_token": "my-token", "expires_in": 3600}`}
+		a := authenticator.New(
+			"id",
+			"secret",
+			"http://localhost/api",
+			authenticator.WithHTTPClient(httpClient),
+			authenticator.WithMinRefreshInterval(0),
+		)
+
+		_, err := a.Token()
+		Expect(err).ToNot(HaveOccurred())
+
+		httpClient.mu.Lock()
+		httpClient.err = errors.New("connection refused")
+		httpClient.mu.Unlock()
+
+		_, err = a.RefreshAuthToken()
+		Expect(err).To(HaveOccurred())
+
+		token, err := a.Token()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(token).To(Equal("bearer my-token"))
+		Expect(httpClient.calls()).To(Equal(2))
+	})
+
+	It("returns the error when a refresh fails without a cached token", func() {
+		httpClient := &spyHTTPClient{err: errors.New("connection refused")}
+		a := authenticator.New(
+			"id",
+			"secret",
+			"http://localhost/api",
+			authenticator.WithHTTPClient(httpClient),
+		)
+
+		_, err := a.RefreshAuthToken()
+		Expect(authenticator.IsTransientError(err)).To(BeTrue())
+	})
 })
 
 type spyHTTPClient struct {
-	_url  string
-	_body url.Values
+	mu       sync.Mutex
+	response string
+	status   int
+	err      error
+	_calls   int
+	_url     string
+	_body    url.Values
 }
 
 func (s *spyHTTPClient) PostForm(url string, data url.Values) (*http.Response, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s._calls++
 	s._url = url
 	s._body = data
 
+	if s.err != nil {
+		return nil, s.err
+	}
+
+	status := s.status
+	if status == 0 {
+		status = 200
+	}
+
+	response := s.response
+	if response == "" {
+		response = `{"access_token": "my-token"}`
+	}
+
 	reader := &spyReadCloser{
-		strings.NewReader(`{"access_token": "my-token"}`),
+		strings.NewReader(response),
 	}
 
-	return &http.Response{StatusCode: 200, Body: reader}, nil
+	return &http.Response{StatusCode: status, Body: reader}, nil
+}
+
+func (s *spyHTTPClient) calls() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s._calls
 }
 
 func (s *spyHTTPClient) url() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	return s._url
 }
 
 func (s *spyHTTPClient) body() url.Values {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	return s._body
 }
 
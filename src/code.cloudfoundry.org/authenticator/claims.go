@@ -0,0 +1,139 @@
+package authenticator
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Claims are the fields of a UAA token that describe what it grants. They
+// are decoded without verifying the token's signature, and are only used to
+// explain why a token will be rejected.
+type Claims struct {
+	ClientID  string
+	UserName  string
+	Scopes    []string
+	Audience  []string
+	ExpiresAt time.Time
+}
+
+// Claims returns the claims of the current token.
+func (a *Authenticator) Claims() (Claims, error) {
+	token, err := a.Token()
+	if err != nil {
+		return Claims{}, err
+	}
+
+	return DecodeClaims(token)
+}
+
+// DecodeClaims decodes the claims of a JWT, with or without its "bearer"
+// prefix.
+func DecodeClaims(token string) (Claims, error) {
+	if i := strings.IndexByte(token, ' '); i >= 0 {
+		token = token[i+1:]
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Claims{}, errors.New("token is not a JWT")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return Claims{}, fmt.Errorf("failed to decode token claims: %s", err)
+	}
+
+	var raw struct {
+		ClientID string          `json:"client_id"`
+		UserName string          `json:"user_name"`
+		Scope    []string        `json:"scope"`
+		Audience json.RawMessage `json:"aud"`
+		Exp      int64           `json:"exp"`
+	}
+	err = json.Unmarshal(payload, &raw)
+	if err != nil {
+		return Claims{}, fmt.Errorf("failed to unmarshal token claims: %s", err)
+	}
+
+	c := Claims{
+		ClientID: raw.ClientID,
+		UserName: raw.UserName,
+		Scopes:   raw.Scope,
+	}
+
+	// The audience may be a single string or a list.
+	if len(raw.Audience) > 0 {
+		var aud string
+		if json.Unmarshal(raw.Audience, &aud) == nil {
+			c.Audience = []string{aud}
+		} else {
+			json.Unmarshal(raw.Audience, &c.Audience)
+		}
+	}
+
+	if raw.Exp > 0 {
+		c.ExpiresAt = time.Unix(raw.Exp, 0)
+	}
+
+	return c, nil
+}
+
+// HasScope reports whether the token grants scope.
+func (c Claims) HasScope(scope string) bool {
+	for _, s := range c.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Expired reports whether the token has expired. Tokens without an expiry
+// never expire.
+func (c Claims) Expired() bool {
+	return !c.ExpiresAt.IsZero() && time.Now().After(c.ExpiresAt)
+}
+
+// RequireScopes returns an error naming every scope the token lacks.
+func (c Claims) RequireScopes(scopes ...string) error {
+	var missing []string
+	for _, s := range scopes {
+		if !c.HasScope(s) {
+			missing = append(missing, s)
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("token for %s is missing required scopes: %s", c.subject(), strings.Join(missing, ", "))
+	}
+
+	return nil
+}
+
+// RequireAnyScope returns an error if the token grants none of scopes.
+func (c Claims) RequireAnyScope(scopes ...string) error {
+	for _, s := range scopes {
+		if c.HasScope(s) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("token for %s needs one of the scopes %s, has: %s",
+		c.subject(),
+		strings.Join(scopes, ", "),
+		strings.Join(c.Scopes, ", "),
+	)
+}
+
+func (c Claims) subject() string {
+	if c.UserName != "" {
+		return "user " + c.UserName
+	}
+
+	return "client " + c.ClientID
+}
@@ -0,0 +1,83 @@
+package authenticator_test
+
+import (
+	"encoding/base64"
+	"time"
+
+	"code.cloudfoundry.org/authenticator"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Claims", func() {
+	It("decodes the claims of a bearer token", func() {
+		claims, err := authenticator.DecodeClaims("bearer " + jwt(`{
+			"client_id": "capacity-planning",
+			"scope": ["doppler.firehose", "uaa.none"],
+			"aud": "doppler",
+			"exp": 1500000000
+		}`))
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(claims.ClientID).To(Equal("capacity-planning"))
+		Expect(claims.Scopes).To(ConsistOf("doppler.firehose", "uaa.none"))
+		Expect(claims.Audience).To(ConsistOf("doppler"))
+		Expect(claims.ExpiresAt).To(Equal(time.Unix(1500000000, 0)))
+		Expect(claims.Expired()).To(BeTrue())
+	})
+
+	It("returns an error for tokens that are not JWTs", func() {
+		_, err := authenticator.DecodeClaims("bearer opaque-token")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("returns the claims of the current token", func() {
+		httpClient := &spyHTTPClient{
+			response: `{"access_token": "` + jwt(`{"user_name": "admin", "aud": ["cloud_controller", "doppler"]}`) + `"}`,
+		}
+		a := authenticator.New(
+			"cf",
+			"",
+			"http://localhost/api",
+			authenticator.WithHTTPClient(httpClient),
+		)
+
+		claims, err := a.Claims()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(claims.UserName).To(Equal("admin"))
+		Expect(claims.Audience).To(ConsistOf("cloud_controller", "doppler"))
+		Expect(claims.Expired()).To(BeFalse())
+	})
+
+	It("names the missing scopes", func() {
+		claims := authenticator.Claims{
+			ClientID: "capacity-planning",
+			Scopes:   []string{"uaa.none"},
+		}
+
+		err := claims.RequireScopes("uaa.none", "doppler.firehose")
+		Expect(err).To(MatchError("token for client capacity-planning is missing required scopes: doppler.firehose"))
+		Expect(claims.RequireScopes("uaa.none")).To(Succeed())
+	})
+
+	It("requires any one of the scopes", func() {
+		claims := authenticator.Claims{
+			UserName: "admin",
+			Scopes:   []string{"logs.admin"},
+		}
+
+		Expect(claims.RequireAnyScope("doppler.firehose", "logs.admin")).To(Succeed())
+		Expect(claims.RequireAnyScope("doppler.firehose")).To(MatchError(
+			"token for user admin needs one of the scopes doppler.firehose, has: logs.admin",
+		))
+	})
+})
+
+func jwt(claims string) string {
+	enc := base64.RawURLEncoding
+
+	return enc.EncodeToString([]byte(`{"alg":"RS256"}`)) + "." +
+		enc.EncodeToString([]byte(claims)) + "." +
+		enc.EncodeToString([]byte("signature"))
+}
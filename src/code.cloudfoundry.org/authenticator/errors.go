@@ -0,0 +1,54 @@
+package authenticator
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// CredentialsError is returned when UAA rejects the credentials. Retrying
+// will not succeed until the credentials are fixed.
+type CredentialsError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *CredentialsError) Error() string {
+	return fmt.Sprintf("UAA rejected credentials, got %d from /oauth/token: %s", e.StatusCode, e.Body)
+}
+
+// TransientError is returned when UAA could not be reached or failed to
+// handle the request. The request may succeed if retried.
+type TransientError struct {
+	StatusCode int
+	Err        error
+}
+
+func (e *TransientError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("failed to request token from UAA: %s", e.Err)
+	}
+
+	return fmt.Sprintf("Expected 200 status code from /oauth/token, got %d", e.StatusCode)
+}
+
+// IsCredentialsError reports whether err is a CredentialsError.
+func IsCredentialsError(err error) bool {
+	_, ok := err.(*CredentialsError)
+	return ok
+}
+
+// IsTransientError reports whether err is a TransientError.
+func IsTransientError(err error) bool {
+	_, ok := err.(*TransientError)
+	return ok
+}
+
+// statusError classifies a non-200 response from /oauth/token.
+func statusError(statusCode int, body []byte) error {
+	switch statusCode {
+	case http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden:
+		return &CredentialsError{StatusCode: statusCode, Body: string(body)}
+	default:
+		return &TransientError{StatusCode: statusCode}
+	}
+}
@@ -0,0 +1,82 @@
+// Package backoff computes exponentially increasing delays with jitter, so
+// that many processes retrying against the same service spread out their
+// attempts.
+package backoff
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Backoff doubles its delay after every attempt, from min up to max. Each
+// delay is jittered to between half and all of the nominal value.
+type Backoff struct {
+	min time.Duration
+	max time.Duration
+
+	mu      sync.Mutex
+	current time.Duration
+	rand    *rand.Rand
+}
+
+func New(min, max time.Duration) *Backoff {
+	return &Backoff{
+		min:  min,
+		max:  max,
+		rand: rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Next returns the delay before the next attempt.
+func (b *Backoff) Next() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.current *= 2
+	if b.current < b.min {
+		b.current = b.min
+	}
+	if b.current > b.max {
+		b.current = b.max
+	}
+
+	half := b.current / 2
+	if half <= 0 {
+		return b.current
+	}
+
+	return half + time.Duration(b.rand.Int63n(int64(half)+1))
+}
+
+// Max makes the next delay the longest one, for failures that are not
+// expected to clear up quickly.
+func (b *Backoff) Max() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.current = b.max
+}
+
+// Reset starts the delays again from min after a successful attempt.
+func (b *Backoff) Reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.current = 0
+}
+
+// Wait sleeps for the next delay. It returns false if the context is done
+// before the delay elapses.
+func (b *Backoff) Wait(ctx context.Context) bool {
+	t := time.NewTimer(b.Next())
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
@@ -0,0 +1,53 @@
+package backoff_test
+
+import (
+	"context"
+	"time"
+
+	"code.cloudfoundry.org/backoff"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Backoff", func() {
+	It("doubles the delay up to the maximum, with jitter", func() {
+		b := backoff.New(time.Second, 8*time.Second)
+
+		for _, nominal := range []time.Duration{1, 2, 4, 8, 8} {
+			d := b.Next()
+			Expect(d).To(BeNumerically(">=", nominal*time.Second/2))
+			Expect(d).To(BeNumerically("<=", nominal*time.Second))
+		}
+	})
+
+	It("starts again from the minimum after a reset", func() {
+		b := backoff.New(time.Second, 8*time.Second)
+		b.Next()
+		b.Next()
+
+		b.Reset()
+		Expect(b.Next()).To(BeNumerically("<=", time.Second))
+	})
+
+	It("jumps to the maximum", func() {
+		b := backoff.New(time.Second, 8*time.Second)
+
+		b.Max()
+		Expect(b.Next()).To(BeNumerically(">=", 4*time.Second))
+	})
+
+	It("stops waiting when the context is done", func() {
+		b := backoff.New(time.Hour, time.Hour)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		Expect(b.Wait(ctx)).To(BeFalse())
+	})
+
+	It("waits for the delay", func() {
+		b := backoff.New(time.Millisecond, time.Millisecond)
+
+		Expect(b.Wait(context.Background())).To(BeTrue())
+	})
+})
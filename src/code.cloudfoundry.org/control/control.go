@@ -0,0 +1,353 @@
+// Package control serves an HTTP API for changing an emitter's load while
+// it runs, so that a test can move between rates without restarting the
+// emitter and resetting its counters.
+//
+// The API is:
+//
+//	GET  /                 rate, paused, settings and counts as JSON
+//	PUT  /rate             {"rate": <events per second>}
+//	POST /pause
+//	POST /resume
+//	PUT  /settings/<name>  {"value": "<value>"}
+//
+// Every response is the status, as returned by GET /. Requests other than
+// GET / must present the controller's token, if it has one. The API is only
+// served without a token on a loopback address.
+package control
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Rater is anything with a target rate that can be changed.
+type Rater interface {
+	SetRate(float64)
+}
+
+// Setting is a named value that can be read and changed, e.g. the size of
+// the emitted payloads.
+type Setting struct {
+	Get func() string
+	Set func(string) error
+}
+
+// Status is the state of the emitter.
+type Status struct {
+	Rate     float64           `json:"rate"`
+	Paused   bool              `json:"paused"`
+	Settings map[string]string `json:"settings,omitempty"`
+	Counts   map[string]int64  `json:"counts,omitempty"`
+}
+
+// Controller tracks the target rate of a rater and whether it is paused.
+// Rates set while paused are applied on resume. It is itself a Rater, so a
+// load profile can drive the rate through it and still be paused; rates set
+// through the API are replaced by the profile's next rate.
+type Controller struct {
+	rater    Rater
+	token    string
+	maxRate  float64
+	counts   func() map[string]int64
+	settings map[string]Setting
+
+	mu     sync.Mutex
+	rate   float64
+	paused bool
+}
+
+// New returns a controller for a rater that is currently at rate.
+func New(r Rater, rate float64, opts ...controllerOpt) *Controller {
+	c := &Controller{
+		rater:    r,
+		rate:     rate,
+		counts:   func() map[string]int64 { return nil },
+		settings: make(map[string]Setting),
+	}
+
+	for _, o := range opts {
+		o(c)
+	}
+
+	return c
+}
+
+type controllerOpt func(*Controller)
+
+// WithToken requires requests that change anything to present the token
+// as a bearer token. Without it, anyone who can reach the API can change
+// the load.
+func WithToken(token string) controllerOpt {
+	return func(c *Controller) {
+		c.token = token
+	}
+}
+
+// WithMaxRate rejects rates above max that are set through the API. Zero
+// leaves them unbounded.
+func WithMaxRate(max float64) controllerOpt {
+	return func(c *Controller) {
+		c.maxRate = max
+	}
+}
+
+// WithCounts sets a function that returns the counts reported in the
+// status.
+func WithCounts(f func() map[string]int64) controllerOpt {
+	return func(c *Controller) {
+		c.counts = f
+	}
+}
+
+// WithSetting adds a setting that can be changed at /settings/<name>.
+func WithSetting(name string, s Setting) controllerOpt {
+	return func(c *Controller) {
+		c.settings[name] = s
+	}
+}
+
+// ListenAndServe serves the API at addr. It refuses to serve without a
+// token on anything but a loopback address, where anyone who can reach the
+// address could change the load.
+func (c *Controller) ListenAndServe(addr string) error {
+	if c.token == "" && !isLoopback(addr) {
+		return fmt.Errorf("a token is required to serve the control API on %s", addr)
+	}
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	log.Printf("Serving the control API on %s", l.Addr())
+
+	go func() {
+		err := http.Serve(l, c)
+		if err != nil {
+			log.Printf("control API stopped: %s", err)
+		}
+	}()
+
+	return nil
+}
+
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// SetRate changes the target rate. While paused, the rate is applied on
+// resume.
+func (c *Controller) SetRate(rate float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.rate = rate
+	if !c.paused {
+		c.rater.SetRate(rate)
+	}
+}
+
+// Pause stops emission until Resume is called.
+func (c *Controller) Pause() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.paused = true
+	c.rater.SetRate(0)
+}
+
+// Resume restores the target rate.
+func (c *Controller) Resume() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.paused = false
+	c.rater.SetRate(c.rate)
+}
+
+// Status returns the state of the emitter.
+func (c *Controller) Status() Status {
+	c.mu.Lock()
+	s := Status{
+		Rate:   c.rate,
+		Paused: c.paused,
+	}
+	c.mu.Unlock()
+
+	if len(c.settings) > 0 {
+		s.Settings = make(map[string]string, len(c.settings))
+		for name, setting := range c.settings {
+			s.Settings[name] = setting.Get()
+		}
+	}
+	s.Counts = c.counts()
+
+	return s
+}
+
+func (c *Controller) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimRight(r.URL.Path, "/")
+
+	if path == "" {
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, http.MethodGet)
+			return
+		}
+		c.writeStatus(w)
+		return
+	}
+
+	if !c.authorized(r) {
+		writeError(w, http.StatusUnauthorized, "missing or invalid token")
+		return
+	}
+
+	switch {
+	case path == "/rate":
+		if r.Method != http.MethodPut {
+			methodNotAllowed(w, http.MethodPut)
+			return
+		}
+		c.handleRate(w, r)
+	case path == "/pause" || path == "/resume":
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, http.MethodPost)
+			return
+		}
+		if path == "/pause" {
+			c.Pause()
+		} else {
+			c.Resume()
+		}
+		c.writeStatus(w)
+	case strings.HasPrefix(path, "/settings/"):
+		if r.Method != http.MethodPut {
+			methodNotAllowed(w, http.MethodPut)
+			return
+		}
+		c.handleSetting(w, r, strings.TrimPrefix(path, "/settings/"))
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+func (c *Controller) handleRate(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Rate *float64 `json:"rate"`
+	}
+	err := readJSON(r, &body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if body.Rate == nil || *body.Rate < 0 || math.IsNaN(*body.Rate) || math.IsInf(*body.Rate, 0) {
+		writeError(w, http.StatusBadRequest, "rate must be a number that is not negative")
+		return
+	}
+	if c.maxRate > 0 && *body.Rate > c.maxRate {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("rate must not be above %g", c.maxRate))
+		return
+	}
+
+	log.Printf("control: setting rate to %g", *body.Rate)
+	c.SetRate(*body.Rate)
+	c.writeStatus(w)
+}
+
+func (c *Controller) handleSetting(w http.ResponseWriter, r *http.Request, name string) {
+	setting, ok := c.settings[name]
+	if !ok {
+		names := make([]string, 0, len(c.settings))
+		for n := range c.settings {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		writeError(w, http.StatusNotFound, fmt.Sprintf("unknown setting %q, settings are: %s", name, strings.Join(names, ", ")))
+		return
+	}
+
+	var body struct {
+		Value *string `json:"value"`
+	}
+	err := readJSON(r, &body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if body.Value == nil {
+		writeError(w, http.StatusBadRequest, "value is required")
+		return
+	}
+
+	err = setting.Set(*body.Value)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	log.Printf("control: setting %s to %q", name, *body.Value)
+	c.writeStatus(w)
+}
+
+func (c *Controller) authorized(r *http.Request) bool {
+	if c.token == "" {
+		return true
+	}
+
+	given := r.Header.Get("Authorization")
+	if !strings.HasPrefix(strings.ToLower(given), "bearer ") {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(given[len("bearer "):]), []byte(c.token)) == 1
+}
+
+func (c *Controller) writeStatus(w http.ResponseWriter) {
+	writeJSON(w, http.StatusOK, c.Status())
+}
+
+func readJSON(r *http.Request, v interface{}) error {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(nil, r.Body, 64*1024))
+	if err != nil {
+		return fmt.Errorf("failed to read body: %s", err)
+	}
+
+	err = json.Unmarshal(body, v)
+	if err != nil {
+		return fmt.Errorf("invalid JSON body: %s", err)
+	}
+
+	return nil
+}
+
+func methodNotAllowed(w http.ResponseWriter, allowed string) {
+	w.Header().Set("Allow", allowed)
+	writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
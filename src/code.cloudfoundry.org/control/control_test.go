@@ -0,0 +1,176 @@
+package control_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"code.cloudfoundry.org/control"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Controller", func() {
+	var (
+		rater *spyRater
+		size  string
+		c     *control.Controller
+	)
+
+	BeforeEach(func() {
+		rater = &spyRater{}
+		size = "fixed:1000"
+		c = control.New(rater, 100,
+			control.WithCounts(func() map[string]int64 {
+				return map[string]int64{"sent": 42}
+			}),
+			control.WithSetting("size", control.Setting{
+				Get: func() string { return size },
+				Set: func(v string) error {
+					if !strings.HasPrefix(v, "fixed:") {
+						return errors.New("invalid size")
+					}
+					size = v
+					return nil
+				},
+			}),
+		)
+	})
+
+	do := func(method, path, body string) (int, map[string]interface{}) {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		c.ServeHTTP(rec, req)
+
+		var resp map[string]interface{}
+		Expect(json.Unmarshal(rec.Body.Bytes(), &resp)).To(Succeed())
+
+		return rec.Code, resp
+	}
+
+	It("reports the status", func() {
+		code, resp := do(http.MethodGet, "/", "")
+		Expect(code).To(Equal(http.StatusOK))
+		Expect(resp).To(Equal(map[string]interface{}{
+			"rate":     100.0,
+			"paused":   false,
+			"settings": map[string]interface{}{"size": "fixed:1000"},
+			"counts":   map[string]interface{}{"sent": 42.0},
+		}))
+	})
+
+	It("changes the rate", func() {
+		code, resp := do(http.MethodPut, "/rate", `{"rate": 2500.5}`)
+		Expect(code).To(Equal(http.StatusOK))
+		Expect(resp["rate"]).To(Equal(2500.5))
+		Expect(rater.rate).To(Equal(2500.5))
+	})
+
+	It("rejects invalid rates", func() {
+		for _, body := range []string{`{"rate": -1}`, `{}`, `nope`} {
+			code, resp := do(http.MethodPut, "/rate", body)
+			Expect(code).To(Equal(http.StatusBadRequest))
+			Expect(resp).To(HaveKey("error"))
+		}
+		Expect(rater.calls).To(Equal(0))
+	})
+
+	It("rejects rates above the max rate", func() {
+		c = control.New(rater, 100, control.WithMaxRate(1000))
+
+		code, resp := do(http.MethodPut, "/rate", `{"rate": 1000.5}`)
+		Expect(code).To(Equal(http.StatusBadRequest))
+		Expect(resp["error"]).To(Equal("rate must not be above 1000"))
+		Expect(rater.calls).To(Equal(0))
+
+		code, _ = do(http.MethodPut, "/rate", `{"rate": 1000}`)
+		Expect(code).To(Equal(http.StatusOK))
+		Expect(rater.rate).To(Equal(1000.0))
+	})
+
+	It("pauses and resumes at the latest rate", func() {
+		code, resp := do(http.MethodPost, "/pause", "")
+		Expect(code).To(Equal(http.StatusOK))
+		Expect(resp["paused"]).To(BeTrue())
+		Expect(rater.rate).To(Equal(0.0))
+
+		c.SetRate(300)
+		Expect(rater.rate).To(Equal(0.0))
+
+		code, resp = do(http.MethodPost, "/resume", "")
+		Expect(code).To(Equal(http.StatusOK))
+		Expect(resp["paused"]).To(BeFalse())
+		Expect(rater.rate).To(Equal(300.0))
+	})
+
+	It("changes settings", func() {
+		code, resp := do(http.MethodPut, "/settings/size", `{"value": "fixed:10"}`)
+		Expect(code).To(Equal(http.StatusOK))
+		Expect(resp["settings"]).To(Equal(map[string]interface{}{"size": "fixed:10"}))
+
+		code, resp = do(http.MethodPut, "/settings/size", `{"value": "huge"}`)
+		Expect(code).To(Equal(http.StatusBadRequest))
+		Expect(resp["error"]).To(Equal("invalid size"))
+
+		code, resp = do(http.MethodPut, "/settings/colour", `{"value": "red"}`)
+		Expect(code).To(Equal(http.StatusNotFound))
+		Expect(resp["error"]).To(ContainSubstring("settings are: size"))
+	})
+
+	It("rejects the wrong methods", func() {
+		code, _ := do(http.MethodGet, "/rate", "")
+		Expect(code).To(Equal(http.StatusMethodNotAllowed))
+
+		code, _ = do(http.MethodPost, "/", "")
+		Expect(code).To(Equal(http.StatusMethodNotAllowed))
+	})
+
+	It("requires the token for changes", func() {
+		c = control.New(rater, 100, control.WithToken("secret"))
+
+		code, _ := do(http.MethodGet, "/", "")
+		Expect(code).To(Equal(http.StatusOK))
+
+		code, _ = do(http.MethodPost, "/pause", "")
+		Expect(code).To(Equal(http.StatusUnauthorized))
+
+		req := httptest.NewRequest(http.MethodPost, "/pause", nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		rec := httptest.NewRecorder()
+		c.ServeHTTP(rec, req)
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(rater.rate).To(Equal(0.0))
+	})
+
+	Describe("ListenAndServe", func() {
+		It("refuses to serve without a token on a public address", func() {
+			err := c.ListenAndServe(":0")
+			Expect(err).To(MatchError("a token is required to serve the control API on :0"))
+
+			err = c.ListenAndServe("0.0.0.0:0")
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("serves without a token on a loopback address", func() {
+			Expect(c.ListenAndServe("127.0.0.1:0")).To(Succeed())
+		})
+
+		It("serves with a token on a public address", func() {
+			c = control.New(rater, 100, control.WithToken("secret"))
+			Expect(c.ListenAndServe(":0")).To(Succeed())
+		})
+	})
+})
+
+type spyRater struct {
+	rate  float64
+	calls int
+}
+
+func (s *spyRater) SetRate(r float64) {
+	s.rate = r
+	s.calls++
+}
@@ -0,0 +1,89 @@
+package datadogreporter
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ringSeconds is how many seconds a Counter can hold between calls to
+// Samples. Events older than that share a bucket with newer seconds, so
+// reports may not be further apart than this.
+const ringSeconds = 1024
+
+// Counter counts events into per-second buckets so that a report can show
+// how a count was distributed over its interval. The buckets are a fixed
+// ring indexed by unix second, so Add does not allocate, and concurrent
+// calls to Add only share a read lock. Samples must be called at least
+// every ringSeconds for the distribution to be accurate. The zero value is
+// ready to use.
+type Counter struct {
+	// start is the first second that has not been sampled. It is zero
+	// until the first Add or Samples.
+	start   int64
+	buckets [ringSeconds]int64
+
+	// mu is held for reading by Add and for writing by Samples, so that
+	// an Add is never counted into a bucket that is being drained.
+	mu sync.RWMutex
+}
+
+// Add records n events in the current second.
+func (c *Counter) Add(n int64) {
+	sec := time.Now().Unix()
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	start := c.init(sec)
+	if sec < start {
+		sec = start
+	}
+	atomic.AddInt64(&c.buckets[sec%ringSeconds], n)
+}
+
+// Samples returns a [timestamp, count] pair for every second from the
+// previous call, or from the start of the window on the first call, up to,
+// but not including, the end of the window. Seconds without events are
+// reported as zero. Events at or after the end are kept for the next call.
+func (c *Counter) Samples(w Window) [][]int64 {
+	until := w.End.Unix()
+	from := w.Start.Unix()
+	if w.Start.IsZero() || from < until-ringSeconds {
+		from = until - ringSeconds
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	start := c.init(from)
+	if until <= start {
+		return nil
+	}
+
+	atomic.StoreInt64(&c.start, until)
+
+	samples := make([][]int64, 0, until-start)
+	for sec := start; sec < until; sec++ {
+		var n int64
+		if until-sec <= ringSeconds {
+			n = atomic.SwapInt64(&c.buckets[sec%ringSeconds], 0)
+		}
+		samples = append(samples, []int64{sec, n})
+	}
+
+	return samples
+}
+
+// init sets the start to sec if it has not been set, and returns the
+// start.
+func (c *Counter) init(sec int64) int64 {
+	start := atomic.LoadInt64(&c.start)
+	if start != 0 {
+		return start
+	}
+	if atomic.CompareAndSwapInt64(&c.start, 0, sec) {
+		return sec
+	}
+	return atomic.LoadInt64(&c.start)
+}
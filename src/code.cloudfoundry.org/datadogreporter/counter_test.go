@@ -0,0 +1,88 @@
+package datadogreporter_test
+
+import (
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/datadogreporter"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Counter", func() {
+	It("reports a sample for every second before the end of the window", func() {
+		var c datadogreporter.Counter
+		start := time.Now().Unix()
+
+		c.Add(3)
+		c.Add(4)
+		time.Sleep(time.Until(time.Unix(start+1, 0)))
+		c.Add(5)
+
+		Expect(c.Samples(window(start, start+1))).To(Equal([][]int64{
+			{start, 7},
+		}))
+		Expect(c.Samples(window(start+1, start+3))).To(Equal([][]int64{
+			{start + 1, 5},
+			{start + 2, 0},
+		}))
+		Expect(c.Samples(window(start+1, start+3))).To(BeEmpty())
+	})
+
+	It("counts concurrent events", func() {
+		var c datadogreporter.Counter
+		start := time.Now().Unix()
+
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 1000; j++ {
+					c.Add(1)
+				}
+			}()
+		}
+		wg.Wait()
+
+		var total int64
+		for _, s := range c.Samples(window(start, time.Now().Unix()+1)) {
+			Expect(s[0]).To(BeNumerically(">=", start))
+			total += s[1]
+		}
+		Expect(total).To(Equal(int64(8000)))
+	})
+
+	It("reports zero for seconds that have left the ring", func() {
+		var c datadogreporter.Counter
+		start := time.Now().Unix()
+
+		c.Add(3)
+		samples := c.Samples(window(start, start+2000))
+
+		Expect(samples).To(HaveLen(2000))
+		Expect(samples[0]).To(Equal([]int64{start, 0}))
+		Expect(samples[1999]).To(Equal([]int64{start + 1999, 0}))
+	})
+
+	It("reports zero for every second of the window when nothing was added", func() {
+		var c datadogreporter.Counter
+
+		Expect(c.Samples(window(100, 103))).To(Equal([][]int64{
+			{100, 0},
+			{101, 0},
+			{102, 0},
+		}))
+		Expect(c.Samples(window(103, 104))).To(Equal([][]int64{
+			{103, 0},
+		}))
+	})
+})
+
+func window(start, end int64) datadogreporter.Window {
+	return datadogreporter.Window{
+		Start: time.Unix(start, 0),
+		End:   time.Unix(end, 0),
+	}
+}
@@ -1,18 +1,27 @@
 package datadogreporter
 
 import (
-	"bytes"
+	"context"
 	"crypto/tls"
-	"encoding/json"
-	"io"
-	"io/ioutil"
 	"log"
+	"math"
 	"net/http"
-	"net/url"
+	"sort"
 	"time"
 )
 
-const datadogAddr = "https://app.datadoghq.com/api/v1/series"
+// Resolutions control how the per-second samples in a point are reported.
+const (
+	// ResolutionInterval reports one total per series for each interval.
+	ResolutionInterval = "interval"
+
+	// ResolutionSecond reports every per-second sample.
+	ResolutionSecond = "second"
+
+	// ResolutionSummary reports the interval total along with the min,
+	// max, p50 and p99 of the per-second samples.
+	ResolutionSummary = "summary"
+)
 
 type DatadogReporter struct {
 	apiKey       string
@@ -21,7 +30,9 @@ type DatadogReporter struct {
 	host         string
 	pointBuilder pointBuilder
 	httpClient   httpClient
+	sink         Sink
 	interval     time.Duration
+	resolution   string
 }
 
 func New(
@@ -31,83 +42,165 @@ func New(
 	pointBuilder pointBuilder,
 	opts ...reporterOpt,
 ) *DatadogReporter {
-	httpClient := &http.Client{
-		Timeout: 30 * time.Second,
-		Transport: &http.Transport{
-			TLSClientConfig:   &tls.Config{},
-			DisableKeepAlives: true,
-		},
-	}
-
 	r := &DatadogReporter{
 		apiKey:       apiKey,
 		jobName:      jobName,
 		instanceID:   instanceID,
 		pointBuilder: pointBuilder,
-		httpClient:   httpClient,
+		httpClient:   newHTTPClient(),
 		interval:     time.Minute,
+		resolution:   ResolutionInterval,
 	}
 
 	for _, o := range opts {
 		o(r)
 	}
-	return r
-}
 
-func (r *DatadogReporter) Run() {
-	dURL, err := url.Parse(datadogAddr)
-	if err != nil {
-		log.Fatalf("Failed to parse datadog URL: %s", err)
+	switch r.resolution {
+	case ResolutionInterval, ResolutionSecond, ResolutionSummary:
+	default:
+		log.Fatalf("Invalid resolution %q, must be interval, second or summary", r.resolution)
 	}
-	query := url.Values{
-		"api_key": []string{r.apiKey},
+
+	if r.interval > ringSeconds*time.Second {
+		log.Fatalf("Invalid report interval %s, must be at most %s", r.interval, ringSeconds*time.Second)
 	}
-	dURL.RawQuery = query.Encode()
 
-	ticker := time.NewTicker(r.interval)
-	for range ticker.C {
-		body, err := r.buildRequestBody()
-		if err != nil {
-			log.Printf("failed to build request body for datadog: %s", err)
-			continue
-		}
+	if r.sink == nil {
+		r.sink = NewDatadogSink(r.apiKey, r.httpClient)
+	}
 
-		log.Printf("Sending point to datadog: %s", body)
+	return r
+}
 
-		response, err := r.httpClient.Post(dURL.String(), "application/json", body)
-		if err != nil {
-			log.Printf("failed to post to datadog: %s", err)
-			continue
-		}
-		defer response.Body.Close()
+// Window is the span of wall-clock time covered by a report.
+type Window struct {
+	Start time.Time
+	End   time.Time
+}
 
-		if response.StatusCode > 299 || response.StatusCode < 200 {
-			respBody, _ := ioutil.ReadAll(response.Body)
+// Run reports points at every interval boundary of the wall clock, so that
+// reports from different processes cover the same windows. When the context
+// is cancelled it writes a final report, covering everything up to the
+// current second, and returns.
+func (r *DatadogReporter) Run(ctx context.Context) {
+	for {
+		end := time.Now().Truncate(r.interval).Add(r.interval)
+		timer := time.NewTimer(time.Until(end))
 
-			log.Printf("Expected successful status code from Datadog, got %d", response.StatusCode)
-			log.Printf("Response: %s", respBody)
-			continue
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			r.report(Window{
+				Start: end.Add(-r.interval),
+				End:   time.Now().Truncate(time.Second).Add(time.Second),
+			})
+			return
+		case <-timer.C:
+			r.report(Window{
+				Start: end.Add(-r.interval),
+				End:   end,
+			})
 		}
 	}
 }
 
-func (r *DatadogReporter) buildRequestBody() (io.Reader, error) {
-	points := r.pointBuilder.BuildPoints()
+func (r *DatadogReporter) report(w Window) {
+	err := r.sink.Write(r.buildPoints(w))
+	if err != nil {
+		log.Printf("failed to write points: %s", err)
+	}
+}
+
+func (r *DatadogReporter) buildPoints(w Window) []Point {
+	start := w.Start.Unix()
 
-	for i, p := range points {
+	var points []Point
+	for _, p := range r.pointBuilder.BuildPoints(w) {
 		p.Host = r.host
 		p.Tags = append(p.Tags, "job_name:"+r.jobName)
 		p.Tags = append(p.Tags, "instance_index:"+r.instanceID)
 
-		points[i] = p
+		switch r.resolution {
+		case ResolutionSecond:
+			points = append(points, p)
+		case ResolutionSummary:
+			points = append(points, total(p, start))
+			if !p.Absolute {
+				points = append(points, summarize(p, start)...)
+			}
+		default:
+			points = append(points, total(p, start))
+		}
 	}
 
-	data, err := json.Marshal(map[string][]Point{"series": points})
-	if err != nil {
-		return nil, err
+	return points
+}
+
+// total collapses the samples in a point into a single sum stamped with
+// the start of the window. Absolute points keep their latest sample.
+func total(p Point, start int64) Point {
+	if p.Absolute {
+		if len(p.Points) > 0 {
+			p.Points = [][]int64{{start, p.Points[len(p.Points)-1][1]}}
+		}
+		return p
+	}
+
+	var sum int64
+	for _, v := range p.Points {
+		sum += v[1]
 	}
+	p.Points = [][]int64{{start, sum}}
 
-	return bytes.NewBuffer(data), nil
+	return p
+}
+
+// summarize reports the distribution of the per-second samples in a point.
+func summarize(p Point, start int64) []Point {
+	if len(p.Points) < 2 {
+		return nil
+	}
+
+	values := make([]int64, 0, len(p.Points))
+	for _, v := range p.Points {
+		values = append(values, v[1])
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+
+	stats := []struct {
+		suffix string
+		value  int64
+	}{
+		{"min", values[0]},
+		{"max", values[len(values)-1]},
+		{"p50", percentile(values, 50)},
+		{"p99", percentile(values, 99)},
+	}
+
+	summary := make([]Point, 0, len(stats))
+	for _, s := range stats {
+		summary = append(summary, Point{
+			Metric:   p.Metric + ".per_second." + s.suffix,
+			Points:   [][]int64{{start, s.value}},
+			Type:     "gauge",
+			Host:     p.Host,
+			Tags:     p.Tags,
+			Absolute: true,
+		})
+	}
+
+	return summary
+}
+
+// percentile returns the nearest-rank percentile of sorted values.
+func percentile(sorted []int64, p int) int64 {
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+
+	return sorted[rank-1]
 }
 
 type Point struct {
@@ -116,14 +209,51 @@ type Point struct {
 	Type   string    `json:"type"`
 	Host   string    `json:"host"`
 	Tags   []string  `json:"tags"`
+
+	// Absolute marks points whose values are levels, such as a rate,
+	// rather than counts. Their samples are not summed.
+	Absolute bool `json:"-"`
+}
+
+// RatePoints returns gauges of a rate in events per second at the start of
+// the window. Points carry whole numbers, so the rate is reported both
+// rounded, as metric, and in thousandths of an event, as metric_milli, so
+// that fractional rates are not lost.
+func RatePoints(metric string, w Window, rate float64, tags []string) []Point {
+	return []Point{
+		{
+			Metric:   metric,
+			Points:   [][]int64{{w.Start.Unix(), int64(math.Round(rate))}},
+			Type:     "gauge",
+			Tags:     tags,
+			Absolute: true,
+		},
+		{
+			Metric:   metric + "_milli",
+			Points:   [][]int64{{w.Start.Unix(), int64(math.Round(rate * 1000))}},
+			Type:     "gauge",
+			Tags:     tags,
+			Absolute: true,
+		},
+	}
 }
 
 type pointBuilder interface {
-	BuildPoints() []Point
+	BuildPoints(Window) []Point
 }
 
 type httpClient interface {
-	Post(string, string, io.Reader) (*http.Response, error)
+	Do(*http.Request) (*http.Response, error)
+}
+
+func newHTTPClient() *http.Client {
+	return &http.Client{
+		Timeout: 30 * time.Second,
+		Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{},
+			DisableKeepAlives: true,
+		},
+	}
 }
 
 type reporterOpt func(*DatadogReporter)
@@ -145,3 +275,19 @@ func WithInterval(d time.Duration) reporterOpt {
 		r.interval = d
 	}
 }
+
+// WithResolution sets how per-second samples are reported. See the
+// Resolution constants.
+func WithResolution(resolution string) reporterOpt {
+	return func(r *DatadogReporter) {
+		r.resolution = resolution
+	}
+}
+
+// WithSink replaces the default Datadog sink. The API key and HTTP client
+// given to the reporter are ignored when a sink is provided.
+func WithSink(s Sink) reporterOpt {
+	return func(r *DatadogReporter) {
+		r.sink = s
+	}
+}
@@ -1,7 +1,8 @@
 package datadogreporter_test
 
 import (
-	"io"
+	"context"
+	"encoding/json"
 	"io/ioutil"
 	"net/http"
 	"sync"
@@ -27,17 +28,17 @@ var _ = Describe("DatadogReporter", func() {
 			datadogreporter.WithInterval(10*time.Millisecond),
 			datadogreporter.WithHTTPClient(httpClient),
 		)
-		go reporter.Run()
+		go reporter.Run(context.Background())
 
 		Eventually(pointBuilder.buildCalled).Should(BeNumerically(">", 1))
 		Eventually(httpClient.postCount).Should(BeNumerically(">", 1))
 		Eventually(httpClient.url).Should(Equal("https://app.datadoghq.com/api/v1/series?api_key=api-key"))
 		Eventually(httpClient.contentType).Should(Equal("application/json"))
-		Eventually(httpClient.body).Should(MatchJSON(`{
+		Eventually(httpClient.bodyWithoutTimestamps).Should(MatchJSON(`{
 			"series": [
 				{
 					"metric": "capacity_planning.sent",
-					"points": [[1234, 4321]],
+					"points": [[0, 4321]],
 					"type": "gauge",
 					"host": "abcdefg",
 					"tags": [
@@ -49,7 +50,7 @@ var _ = Describe("DatadogReporter", func() {
 				},
 				{
 					"metric": "capacity_planning.read",
-					"points": [[1234, 4321]],
+					"points": [[0, 4321]],
 					"type": "gauge",
 					"host": "abcdefg",
 					"tags": [
@@ -62,18 +63,217 @@ var _ = Describe("DatadogReporter", func() {
 			]
 		}`))
 	})
+
+	It("writes data points to the configured sink", func() {
+		pointBuilder := &spyPointBuilder{}
+		sink := &spySink{}
+
+		reporter := datadogreporter.New(
+			"",
+			"job-name",
+			"instance-id",
+			pointBuilder,
+			datadogreporter.WithHost("abcdefg"),
+			datadogreporter.WithInterval(10*time.Millisecond),
+			datadogreporter.WithSink(sink),
+		)
+		go reporter.Run(context.Background())
+
+		Eventually(sink.writes).ShouldNot(BeEmpty())
+		points := sink.writes()[0]
+		Expect(points).To(HaveLen(2))
+		Expect(points[0].Host).To(Equal("abcdefg"))
+		Expect(points[0].Tags).To(ConsistOf(
+			"event_type:metrics",
+			"api_version:2",
+			"job_name:job-name",
+			"instance_index:instance-id",
+		))
+	})
+
+	It("reports windows aligned to the wall clock", func() {
+		pointBuilder := &spyPointBuilder{}
+
+		reporter := datadogreporter.New(
+			"",
+			"job-name",
+			"instance-id",
+			pointBuilder,
+			datadogreporter.WithInterval(100*time.Millisecond),
+			datadogreporter.WithSink(&spySink{}),
+		)
+		go reporter.Run(context.Background())
+
+		Eventually(pointBuilder.windows).Should(HaveLen(2))
+		for _, w := range pointBuilder.windows()[:2] {
+			Expect(w.Start.Equal(w.Start.Truncate(100 * time.Millisecond))).To(BeTrue())
+			Expect(w.End.Sub(w.Start)).To(Equal(100 * time.Millisecond))
+		}
+	})
+
+	It("stamps totals with the start of the window", func() {
+		sink := &spySink{}
+		reporter := datadogreporter.New(
+			"",
+			"job-name",
+			"instance-id",
+			&samplesPointBuilder{samples: [][]int64{{65, 1}, {70, 2}}},
+			datadogreporter.WithInterval(time.Minute),
+			datadogreporter.WithSink(sink),
+		)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		reporter.Run(ctx)
+
+		start := time.Now().Truncate(time.Minute).Unix()
+		Expect(sink.writes()[0][0].Points).To(Equal([][]int64{{start, 3}}))
+	})
+
+	Describe("resolution", func() {
+		var (
+			sink *spySink
+		)
+
+		BeforeEach(func() {
+			sink = &spySink{}
+		})
+
+		run := func(resolution string) []datadogreporter.Point {
+			reporter := datadogreporter.New(
+				"",
+				"job-name",
+				"instance-id",
+				&samplesPointBuilder{samples: [][]int64{{10, 4}, {11, 1}, {12, 2}, {13, 3}}},
+				datadogreporter.WithInterval(15*time.Minute),
+				datadogreporter.WithSink(sink),
+				datadogreporter.WithResolution(resolution),
+			)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			reporter.Run(ctx)
+
+			return sink.writes()[0]
+		}
+
+		It("reports the interval total by default", func() {
+			points := run(datadogreporter.ResolutionInterval)
+
+			Expect(points).To(HaveLen(1))
+			Expect(points[0].Points).To(HaveLen(1))
+			Expect(points[0].Points[0][1]).To(Equal(int64(10)))
+		})
+
+		It("reports the latest sample of absolute points", func() {
+			reporter := datadogreporter.New(
+				"",
+				"job-name",
+				"instance-id",
+				&samplesPointBuilder{samples: [][]int64{{10, 4}, {11, 1}}, absolute: true},
+				datadogreporter.WithInterval(15*time.Minute),
+				datadogreporter.WithSink(sink),
+			)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			reporter.Run(ctx)
+
+			Expect(sink.writes()[0][0].Points[0][1]).To(Equal(int64(1)))
+		})
+
+		It("reports every per-second sample", func() {
+			points := run(datadogreporter.ResolutionSecond)
+
+			Expect(points).To(HaveLen(1))
+			Expect(points[0].Points).To(Equal([][]int64{{10, 4}, {11, 1}, {12, 2}, {13, 3}}))
+		})
+
+		It("reports a summary of the per-second samples", func() {
+			points := run(datadogreporter.ResolutionSummary)
+
+			values := make(map[string]int64)
+			for _, p := range points {
+				Expect(p.Points).To(HaveLen(1))
+				values[p.Metric] = p.Points[0][1]
+			}
+			Expect(values).To(Equal(map[string]int64{
+				"capacity_planning.sent":                3 + 4 + 1 + 2,
+				"capacity_planning.sent.per_second.min": 1,
+				"capacity_planning.sent.per_second.max": 4,
+				"capacity_planning.sent.per_second.p50": 2,
+				"capacity_planning.sent.per_second.p99": 4,
+			}))
+		})
+	})
+
+	It("reports rates rounded and in thousandths", func() {
+		start := time.Unix(60, 0)
+		points := datadogreporter.RatePoints(
+			"capacity_planning.target_rate",
+			datadogreporter.Window{Start: start, End: start.Add(time.Minute)},
+			0.25,
+			[]string{"event_type:logs"},
+		)
+
+		Expect(points).To(Equal([]datadogreporter.Point{
+			{
+				Metric:   "capacity_planning.target_rate",
+				Points:   [][]int64{{60, 0}},
+				Type:     "gauge",
+				Tags:     []string{"event_type:logs"},
+				Absolute: true,
+			},
+			{
+				Metric:   "capacity_planning.target_rate_milli",
+				Points:   [][]int64{{60, 250}},
+				Type:     "gauge",
+				Tags:     []string{"event_type:logs"},
+				Absolute: true,
+			},
+		}))
+	})
+
+	It("writes a final report when the context is cancelled", func() {
+		pointBuilder := &spyPointBuilder{}
+		sink := &spySink{}
+
+		reporter := datadogreporter.New(
+			"",
+			"job-name",
+			"instance-id",
+			pointBuilder,
+			datadogreporter.WithInterval(15*time.Minute),
+			datadogreporter.WithSink(sink),
+		)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			reporter.Run(ctx)
+			close(done)
+		}()
+
+		Consistently(sink.writes).Should(BeEmpty())
+		cancel()
+
+		Eventually(done).Should(BeClosed())
+		Expect(sink.writes()).To(HaveLen(1))
+	})
 })
 
 type spyPointBuilder struct {
 	mu           sync.Mutex
 	_buildCalled int
+	_windows     []datadogreporter.Window
 }
 
-func (s *spyPointBuilder) BuildPoints() []datadogreporter.Point {
+func (s *spyPointBuilder) BuildPoints(w datadogreporter.Window) []datadogreporter.Point {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	s._buildCalled++
+	s._windows = append(s._windows, w)
 
 	return []datadogreporter.Point{
 		{
@@ -101,6 +301,13 @@ func (s *spyPointBuilder) BuildPoints() []datadogreporter.Point {
 	}
 }
 
+func (s *spyPointBuilder) windows() []datadogreporter.Window {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s._windows
+}
+
 func (s *spyPointBuilder) buildCalled() int {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -108,6 +315,44 @@ func (s *spyPointBuilder) buildCalled() int {
 	return s._buildCalled
 }
 
+type samplesPointBuilder struct {
+	samples  [][]int64
+	absolute bool
+}
+
+func (s *samplesPointBuilder) BuildPoints(datadogreporter.Window) []datadogreporter.Point {
+	return []datadogreporter.Point{
+		{
+			Metric:   "capacity_planning.sent",
+			Points:   s.samples,
+			Type:     "gauge",
+			Absolute: s.absolute,
+		},
+	}
+}
+
+type spySink struct {
+	mu      sync.Mutex
+	_writes [][]datadogreporter.Point
+	err     error
+}
+
+func (s *spySink) Write(points []datadogreporter.Point) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s._writes = append(s._writes, points)
+
+	return s.err
+}
+
+func (s *spySink) writes() [][]datadogreporter.Point {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s._writes
+}
+
 type spyReadCloser struct{}
 
 func (s *spyReadCloser) Close() error {
@@ -126,17 +371,17 @@ type spyHTTPClient struct {
 	_body        string
 }
 
-func (s *spyHTTPClient) Post(url string, contentType string, r io.Reader) (*http.Response, error) {
+func (s *spyHTTPClient) Do(req *http.Request) (*http.Response, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	s._postCount++
 
-	body, err := ioutil.ReadAll(r)
+	body, err := ioutil.ReadAll(req.Body)
 	Expect(err).ToNot(HaveOccurred())
 
-	s._url = url
-	s._contentType = contentType
+	s._url = req.URL.String()
+	s._contentType = req.Header.Get("Content-Type")
 	s._body = string(body)
 
 	return &http.Response{StatusCode: 201, Body: &spyReadCloser{}}, nil
@@ -163,6 +408,28 @@ func (s *spyHTTPClient) body() string {
 	return s._body
 }
 
+// bodyWithoutTimestamps returns the body with the timestamp of every point
+// set to zero.
+func (s *spyHTTPClient) bodyWithoutTimestamps() string {
+	var body struct {
+		Series []datadogreporter.Point `json:"series"`
+	}
+	if json.Unmarshal([]byte(s.body()), &body) != nil {
+		return ""
+	}
+
+	for _, p := range body.Series {
+		for _, v := range p.Points {
+			v[0] = 0
+		}
+	}
+
+	data, err := json.Marshal(body)
+	Expect(err).ToNot(HaveOccurred())
+
+	return string(data)
+}
+
 func (s *spyHTTPClient) postCount() int {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -0,0 +1,499 @@
+package datadogreporter
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	datadogAddr = "https://app.datadoghq.com/api/v1/series"
+
+	defaultMaxPendingSeries = 10000
+
+	// maxRequestsPerSubmit bounds how many requests a submission makes
+	// while bisecting a batch that Datadog rejected. Series left over are
+	// retried with backoff.
+	maxRequestsPerSubmit = 16
+)
+
+// Intake limits for the series endpoints. Batches that exceed them are
+// split before they are posted.
+var payloadLimits = map[string]struct {
+	body         int
+	decompressed int
+}{
+	"v1": {body: 3200000, decompressed: 62914560},
+	"v2": {body: 512000, decompressed: 5242880},
+}
+
+// DatadogSink posts points to the Datadog series API. Series that fail to
+// submit because of network errors, rate limiting or server errors are
+// held, up to a bound, and retried with backoff together with any points
+// written in the meantime. Series that Datadog rejects outright, or that
+// are too large to ever submit, are dropped and counted with the series
+// dropped for exceeding the bound. Writes are not blocked while a batch is
+// being posted; their points are held until the next submission.
+type DatadogSink struct {
+	apiKey     string
+	site       string
+	apiVersion string
+	compress   bool
+	addr       string
+	httpClient httpClient
+	spoolPath  string
+	maxPending int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+
+	mu       sync.Mutex
+	pending  []Point
+	inflight []Point
+	dropped  int64
+	backoff  time.Duration
+	retrying bool
+	posting  bool
+	host     string
+	tags     []string
+}
+
+func NewDatadogSink(apiKey string, c httpClient, opts ...datadogSinkOpt) *DatadogSink {
+	s := &DatadogSink{
+		apiKey:     apiKey,
+		apiVersion: "v1",
+		httpClient: c,
+		maxPending: defaultMaxPendingSeries,
+		minBackoff: time.Second,
+		maxBackoff: 5 * time.Minute,
+	}
+
+	for _, o := range opts {
+		o(s)
+	}
+
+	s.addr = s.seriesURL()
+	s.loadSpool()
+
+	return s
+}
+
+// seriesURL returns the series endpoint for the configured site and API
+// version. The v1 API takes the API key in the query string, v2 in a
+// header.
+func (s *DatadogSink) seriesURL() string {
+	addr := datadogAddr
+	if s.site != "" {
+		addr = "https://api." + s.site + "/api/v1/series"
+	}
+
+	if s.apiVersion == "v2" {
+		return strings.Replace(addr, "/api/v1/", "/api/v2/", 1)
+	}
+
+	dURL, err := url.Parse(addr)
+	if err != nil {
+		log.Fatalf("Failed to parse datadog URL: %s", err)
+	}
+	query := url.Values{
+		"api_key": []string{s.apiKey},
+	}
+	dURL.RawQuery = query.Encode()
+
+	return dURL.String()
+}
+
+func (s *DatadogSink) Write(points []Point) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.recordIdentity(points)
+	s.pending = append(s.pending, points...)
+	if n := len(s.pending) - s.maxPending; n > 0 {
+		s.dropped += int64(n)
+		s.pending = append([]Point(nil), s.pending[n:]...)
+	}
+
+	if s.retrying {
+		s.saveSpool()
+		return fmt.Errorf("holding %d series for retry", len(s.pending))
+	}
+
+	if s.posting {
+		s.saveSpool()
+		return nil
+	}
+
+	return s.submit()
+}
+
+// submit posts every pending series. It must be called with mu held; mu is
+// released while the series are posted so that writes are not blocked.
+func (s *DatadogSink) submit() error {
+	s.inflight = s.pending
+	s.pending = nil
+	dropped := s.dropped
+	s.dropped = 0
+	s.posting = true
+
+	batch := s.inflight
+	if dropped > 0 {
+		batch = append(batch[:len(batch):len(batch)], s.droppedPoint(dropped))
+	}
+
+	s.mu.Unlock()
+	requests := maxRequestsPerSubmit
+	handled, rejected, err := s.post(batch, &requests)
+	s.mu.Lock()
+
+	inflight := s.inflight
+	s.inflight = nil
+	s.posting = false
+
+	if err != nil {
+		if handled > len(inflight) {
+			handled = len(inflight)
+		}
+		s.pending = append(append([]Point(nil), inflight[handled:]...), s.pending...)
+		if n := len(s.pending) - s.maxPending; n > 0 {
+			s.dropped += int64(n)
+			s.pending = s.pending[n:]
+		}
+		s.dropped += dropped + int64(rejected)
+
+		s.scheduleRetry()
+		s.saveSpool()
+		return err
+	}
+
+	s.dropped += int64(rejected)
+	s.backoff = 0
+	s.saveSpool()
+
+	return nil
+}
+
+// post submits the points, splitting them into as many requests as the
+// intake limits require. It returns the number of points that were
+// handled, either accepted or rejected, before any error that is worth
+// retrying, and how many of them were rejected. Each request takes one
+// from requests; once none are left the points that remain are returned
+// with an error so that they are retried.
+func (s *DatadogSink) post(points []Point, requests *int) (handled, rejected int, err error) {
+	raw, err := s.marshal(points)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	body := raw
+	if s.compress {
+		body, err = gzipBytes(raw)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+
+	limits := payloadLimits[s.apiVersion]
+	if len(body) > limits.body || len(raw) > limits.decompressed {
+		if len(points) == 1 {
+			log.Printf("dropping series %s: it exceeds the Datadog payload limit", points[0].Metric)
+			return 1, 1, nil
+		}
+
+		return s.postHalves(points, requests)
+	}
+
+	if *requests <= 0 {
+		return 0, 0, fmt.Errorf("holding %d series after %d requests to Datadog", len(points), maxRequestsPerSubmit)
+	}
+	*requests--
+
+	log.Printf("Sending point to datadog: %s", raw)
+
+	req, err := http.NewRequest(http.MethodPost, s.addr, bytes.NewReader(body))
+	if err != nil {
+		return 0, 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.compress {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	if s.apiVersion == "v2" {
+		req.Header.Set("DD-API-KEY", s.apiKey)
+	}
+
+	response, err := s.httpClient.Do(req)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer response.Body.Close()
+
+	code := response.StatusCode
+	if code >= 200 && code <= 299 {
+		return len(points), 0, nil
+	}
+
+	respBody, _ := ioutil.ReadAll(response.Body)
+	switch {
+	case code == http.StatusTooManyRequests || code >= 500 || code < 400:
+		return 0, 0, fmt.Errorf("Expected successful status code from Datadog, got %d: %s", code, respBody)
+	case code == http.StatusUnauthorized || code == http.StatusForbidden:
+		// Every series would be rejected with the same key.
+		log.Printf("dropping %d series: Datadog rejected the API key with %d: %s", len(points), code, respBody)
+		return len(points), len(points), nil
+	case len(points) > 1:
+		// Find the series that were rejected, so the rest are kept.
+		return s.postHalves(points, requests)
+	default:
+		log.Printf("dropping series %s: Datadog rejected it with %d: %s", points[0].Metric, code, respBody)
+		return 1, 1, nil
+	}
+}
+
+// postHalves posts each half of the points in turn.
+func (s *DatadogSink) postHalves(points []Point, requests *int) (handled, rejected int, err error) {
+	half := len(points) / 2
+	handled, rejected, err = s.post(points[:half], requests)
+	if err != nil {
+		return handled, rejected, err
+	}
+
+	restHandled, restRejected, err := s.post(points[half:], requests)
+	return handled + restHandled, rejected + restRejected, err
+}
+
+func (s *DatadogSink) marshal(points []Point) ([]byte, error) {
+	if s.apiVersion != "v2" {
+		return json.Marshal(map[string][]Point{"series": points})
+	}
+
+	series := make([]v2Series, 0, len(points))
+	for _, p := range points {
+		series = append(series, newV2Series(p))
+	}
+
+	return json.Marshal(map[string][]v2Series{"series": series})
+}
+
+func gzipBytes(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+
+	_, err := w.Write(data)
+	if err != nil {
+		return nil, err
+	}
+
+	err = w.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// v2Series is a point in the format expected by the v2 series API.
+type v2Series struct {
+	Metric    string       `json:"metric"`
+	Type      int          `json:"type"`
+	Points    []v2Point    `json:"points"`
+	Resources []v2Resource `json:"resources,omitempty"`
+	Tags      []string     `json:"tags,omitempty"`
+}
+
+type v2Point struct {
+	Timestamp int64 `json:"timestamp"`
+	Value     int64 `json:"value"`
+}
+
+type v2Resource struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+var v2Types = map[string]int{
+	"count": 1,
+	"rate":  2,
+	"gauge": 3,
+}
+
+func newV2Series(p Point) v2Series {
+	s := v2Series{
+		Metric: p.Metric,
+		Type:   v2Types[p.Type],
+		Tags:   p.Tags,
+	}
+
+	for _, v := range p.Points {
+		if len(v) == 2 {
+			s.Points = append(s.Points, v2Point{Timestamp: v[0], Value: v[1]})
+		}
+	}
+
+	if p.Host != "" {
+		s.Resources = []v2Resource{{Name: p.Host, Type: "host"}}
+	}
+
+	return s
+}
+
+func (s *DatadogSink) scheduleRetry() {
+	s.backoff *= 2
+	if s.backoff < s.minBackoff {
+		s.backoff = s.minBackoff
+	}
+	if s.backoff > s.maxBackoff {
+		s.backoff = s.maxBackoff
+	}
+
+	s.retrying = true
+	time.AfterFunc(s.backoff, s.retry)
+}
+
+func (s *DatadogSink) retry() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.retrying = false
+	err := s.submit()
+	if err != nil {
+		log.Printf("failed to retry %d series, next attempt in %s: %s", len(s.pending), s.backoff, err)
+	}
+}
+
+// recordIdentity remembers the host and reporter tags of the latest points
+// so that the dropped series count can be attributed to this reporter.
+func (s *DatadogSink) recordIdentity(points []Point) {
+	for _, p := range points {
+		s.host = p.Host
+		s.tags = s.tags[:0]
+		for _, t := range p.Tags {
+			if strings.HasPrefix(t, "job_name:") || strings.HasPrefix(t, "instance_index:") {
+				s.tags = append(s.tags, t)
+			}
+		}
+	}
+}
+
+func (s *DatadogSink) droppedPoint(dropped int64) Point {
+	return Point{
+		Metric: "capacity_planning.reporter.dropped_series",
+		Points: [][]int64{{time.Now().Unix(), dropped}},
+		Type:   "gauge",
+		Host:   s.host,
+		Tags:   append([]string(nil), s.tags...),
+	}
+}
+
+func (s *DatadogSink) saveSpool() {
+	if s.spoolPath == "" {
+		return
+	}
+
+	if len(s.inflight) == 0 && len(s.pending) == 0 {
+		err := os.Remove(s.spoolPath)
+		if err != nil && !os.IsNotExist(err) {
+			log.Printf("failed to remove spool file: %s", err)
+		}
+		return
+	}
+
+	data, err := json.Marshal(append(s.inflight[:len(s.inflight):len(s.inflight)], s.pending...))
+	if err != nil {
+		log.Printf("failed to marshal pending series: %s", err)
+		return
+	}
+
+	tmp := s.spoolPath + ".tmp"
+	err = ioutil.WriteFile(tmp, data, 0600)
+	if err != nil {
+		log.Printf("failed to write spool file: %s", err)
+		return
+	}
+
+	err = os.Rename(tmp, s.spoolPath)
+	if err != nil {
+		log.Printf("failed to write spool file: %s", err)
+	}
+}
+
+func (s *DatadogSink) loadSpool() {
+	if s.spoolPath == "" {
+		return
+	}
+
+	data, err := ioutil.ReadFile(s.spoolPath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("failed to read spool file: %s", err)
+		}
+		return
+	}
+
+	err = json.Unmarshal(data, &s.pending)
+	if err != nil {
+		log.Printf("failed to unmarshal spool file: %s", err)
+		return
+	}
+
+	log.Printf("Loaded %d pending series from %s", len(s.pending), s.spoolPath)
+}
+
+type datadogSinkOpt func(*DatadogSink)
+
+// WithSpoolPath keeps series that have not been submitted in a file at
+// path so that they are retried after a restart.
+func WithSpoolPath(path string) datadogSinkOpt {
+	return func(s *DatadogSink) {
+		s.spoolPath = path
+	}
+}
+
+// WithMaxPendingSeries bounds the number of series held for retry. The
+// oldest series are dropped first. Values less than one are ignored.
+func WithMaxPendingSeries(n int) datadogSinkOpt {
+	return func(s *DatadogSink) {
+		if n > 0 {
+			s.maxPending = n
+		}
+	}
+}
+
+// WithSite posts to the given Datadog site, e.g. datadoghq.eu or
+// us3.datadoghq.com.
+func WithSite(site string) datadogSinkOpt {
+	return func(s *DatadogSink) {
+		s.site = site
+	}
+}
+
+// WithAPIVersion selects the v1 or v2 series API.
+func WithAPIVersion(v string) datadogSinkOpt {
+	return func(s *DatadogSink) {
+		if v != "" {
+			s.apiVersion = v
+		}
+	}
+}
+
+// WithCompression gzips request bodies.
+func WithCompression(compress bool) datadogSinkOpt {
+	return func(s *DatadogSink) {
+		s.compress = compress
+	}
+}
+
+func WithRetryBackoff(min, max time.Duration) datadogSinkOpt {
+	return func(s *DatadogSink) {
+		s.minBackoff = min
+		s.maxBackoff = max
+	}
+}
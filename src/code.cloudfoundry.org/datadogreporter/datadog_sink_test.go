@@ -0,0 +1,444 @@
+package datadogreporter_test
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/datadogreporter"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("DatadogSink", func() {
+	var (
+		httpClient *flakyHTTPClient
+	)
+
+	BeforeEach(func() {
+		httpClient = &flakyHTTPClient{}
+	})
+
+	It("retries failed submissions merged with later points", func() {
+		httpClient.setFailures(2)
+		s := datadogreporter.NewDatadogSink(
+			"api-key",
+			httpClient,
+			datadogreporter.WithRetryBackoff(10*time.Millisecond, 20*time.Millisecond),
+		)
+
+		Expect(s.Write([]datadogreporter.Point{point("first")})).ToNot(Succeed())
+		Expect(s.Write([]datadogreporter.Point{point("second")})).ToNot(Succeed())
+
+		Eventually(httpClient.successes).Should(Equal(1))
+		Expect(httpClient.lastMetrics()).To(Equal([]string{"first", "second"}))
+
+		Expect(s.Write([]datadogreporter.Point{point("third")})).To(Succeed())
+		Expect(httpClient.lastMetrics()).To(Equal([]string{"third"}))
+	})
+
+	It("posts to the configured site", func() {
+		s := datadogreporter.NewDatadogSink(
+			"api-key",
+			httpClient,
+			datadogreporter.WithSite("datadoghq.eu"),
+		)
+
+		Expect(s.Write([]datadogreporter.Point{point("first")})).To(Succeed())
+		Expect(httpClient.requests()[0].url).To(Equal("https://api.datadoghq.eu/api/v1/series?api_key=api-key"))
+	})
+
+	It("posts gzipped series to the v2 API", func() {
+		s := datadogreporter.NewDatadogSink(
+			"api-key",
+			httpClient,
+			datadogreporter.WithSite("us3.datadoghq.com"),
+			datadogreporter.WithAPIVersion("v2"),
+			datadogreporter.WithCompression(true),
+		)
+
+		p := point("first")
+		p.Host = "abcdefg"
+		Expect(s.Write([]datadogreporter.Point{p})).To(Succeed())
+
+		req := httpClient.requests()[0]
+		Expect(req.url).To(Equal("https://api.us3.datadoghq.com/api/v2/series"))
+		Expect(req.header.Get("DD-API-KEY")).To(Equal("api-key"))
+		Expect(req.header.Get("Content-Encoding")).To(Equal("gzip"))
+		Expect(req.body).To(MatchJSON(`{
+			"series": [
+				{
+					"metric": "first",
+					"type": 3,
+					"points": [{"timestamp": 1234, "value": 4321}],
+					"resources": [{"name": "abcdefg", "type": "host"}],
+					"tags": ["event_type:logs", "job_name:job-name"]
+				}
+			]
+		}`))
+	})
+
+	It("splits batches that exceed the intake limit", func() {
+		s := datadogreporter.NewDatadogSink(
+			"api-key",
+			httpClient,
+			datadogreporter.WithAPIVersion("v2"),
+		)
+
+		var points []datadogreporter.Point
+		for i := 0; i < 2000; i++ {
+			p := point(strings.Repeat("x", 200))
+			points = append(points, p)
+		}
+
+		Expect(s.Write(points)).To(Succeed())
+		Expect(len(httpClient.requests())).To(BeNumerically(">", 1))
+		for _, req := range httpClient.requests() {
+			Expect(len(req.body)).To(BeNumerically("<=", 512000))
+		}
+	})
+
+	It("keeps pending series in the spool file across restarts", func() {
+		dir, err := ioutil.TempDir("", "spool")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.RemoveAll(dir)
+		spool := filepath.Join(dir, "spool.json")
+
+		httpClient.setFailures(1)
+		s := datadogreporter.NewDatadogSink(
+			"api-key",
+			httpClient,
+			datadogreporter.WithSpoolPath(spool),
+			datadogreporter.WithMaxPendingSeries(1),
+			datadogreporter.WithRetryBackoff(time.Hour, time.Hour),
+		)
+		Expect(s.Write([]datadogreporter.Point{point("first"), point("second")})).ToNot(Succeed())
+		Expect(spool).To(BeAnExistingFile())
+
+		restarted := datadogreporter.NewDatadogSink(
+			"api-key",
+			httpClient,
+			datadogreporter.WithSpoolPath(spool),
+		)
+		Expect(restarted.Write([]datadogreporter.Point{point("third")})).To(Succeed())
+		Expect(httpClient.lastMetrics()).To(Equal([]string{"second", "third"}))
+		Expect(spool).ToNot(BeAnExistingFile())
+	})
+
+	It("retries network errors and rate limiting", func() {
+		httpClient.setFailures(2)
+		httpClient.failWith(http.StatusTooManyRequests, errors.New("connection reset"))
+		s := datadogreporter.NewDatadogSink(
+			"api-key",
+			httpClient,
+			datadogreporter.WithRetryBackoff(10*time.Millisecond, 10*time.Millisecond),
+		)
+
+		Expect(s.Write([]datadogreporter.Point{point("first")})).ToNot(Succeed())
+
+		Eventually(httpClient.successes).Should(Equal(1))
+		Expect(httpClient.lastMetrics()).To(Equal([]string{"first"}))
+	})
+
+	It("drops series that Datadog rejects and keeps the rest", func() {
+		httpClient.reject("poison")
+		s := datadogreporter.NewDatadogSink(
+			"api-key",
+			httpClient,
+			datadogreporter.WithRetryBackoff(time.Hour, time.Hour),
+		)
+
+		Expect(s.Write([]datadogreporter.Point{point("first"), point("poison"), point("second")})).To(Succeed())
+		Expect(httpClient.acceptedMetrics()).To(Equal([]string{"first", "second"}))
+
+		Expect(s.Write([]datadogreporter.Point{point("third")})).To(Succeed())
+		Expect(httpClient.lastMetrics()).To(Equal([]string{
+			"third",
+			"capacity_planning.reporter.dropped_series",
+		}))
+		Expect(httpClient.lastSeries()[1].Points[0][1]).To(Equal(int64(1)))
+	})
+
+	It("drops every series when the API key is rejected", func() {
+		httpClient.setFailures(1)
+		httpClient.failWith(http.StatusForbidden, nil)
+		s := datadogreporter.NewDatadogSink(
+			"api-key",
+			httpClient,
+			datadogreporter.WithRetryBackoff(time.Hour, time.Hour),
+		)
+
+		Expect(s.Write([]datadogreporter.Point{point("first"), point("second")})).To(Succeed())
+		Expect(httpClient.requests()).To(BeEmpty())
+
+		Expect(s.Write([]datadogreporter.Point{point("third")})).To(Succeed())
+		Expect(httpClient.lastMetrics()).To(Equal([]string{
+			"third",
+			"capacity_planning.reporter.dropped_series",
+		}))
+		Expect(httpClient.lastSeries()[1].Points[0][1]).To(Equal(int64(2)))
+	})
+
+	It("drops single series that exceed the intake limit", func() {
+		s := datadogreporter.NewDatadogSink(
+			"api-key",
+			httpClient,
+			datadogreporter.WithRetryBackoff(time.Hour, time.Hour),
+		)
+
+		huge := point("huge")
+		for i := 0; i < 300000; i++ {
+			huge.Points = append(huge.Points, []int64{1234, 4321})
+		}
+
+		Expect(s.Write([]datadogreporter.Point{point("first"), huge})).To(Succeed())
+		Expect(httpClient.acceptedMetrics()).To(Equal([]string{"first"}))
+
+		Expect(s.Write([]datadogreporter.Point{point("second")})).To(Succeed())
+		Expect(httpClient.lastMetrics()).To(Equal([]string{
+			"second",
+			"capacity_planning.reporter.dropped_series",
+		}))
+	})
+
+	It("caps the requests made to find rejected series", func() {
+		httpClient.reject("poison")
+		s := datadogreporter.NewDatadogSink(
+			"api-key",
+			httpClient,
+			datadogreporter.WithRetryBackoff(time.Hour, time.Hour),
+		)
+
+		var points []datadogreporter.Point
+		for i := 0; i < 64; i++ {
+			points = append(points, point("poison"))
+		}
+
+		Expect(s.Write(points)).ToNot(Succeed())
+		Expect(httpClient.requests()).To(HaveLen(16))
+	})
+
+	It("does not block writes while a batch is being posted", func() {
+		httpClient := newBlockingHTTPClient()
+		s := datadogreporter.NewDatadogSink("api-key", httpClient)
+
+		done := make(chan error, 1)
+		go func() {
+			done <- s.Write([]datadogreporter.Point{point("first")})
+		}()
+		Eventually(httpClient.started).Should(Receive())
+
+		Expect(s.Write([]datadogreporter.Point{point("second")})).To(Succeed())
+
+		close(httpClient.release)
+		Eventually(done).Should(Receive(BeNil()))
+
+		Expect(s.Write([]datadogreporter.Point{point("third")})).To(Succeed())
+		Eventually(httpClient.started).Should(Receive(Equal([]string{"second", "third"})))
+	})
+
+	It("reports dropped series with the next successful submission", func() {
+		httpClient.setFailures(1)
+		s := datadogreporter.NewDatadogSink(
+			"api-key",
+			httpClient,
+			datadogreporter.WithMaxPendingSeries(1),
+			datadogreporter.WithRetryBackoff(10*time.Millisecond, 10*time.Millisecond),
+		)
+
+		Expect(s.Write([]datadogreporter.Point{point("first"), point("second")})).ToNot(Succeed())
+
+		Eventually(httpClient.successes).Should(Equal(1))
+		Expect(httpClient.lastMetrics()).To(Equal([]string{
+			"second",
+			"capacity_planning.reporter.dropped_series",
+		}))
+		Expect(httpClient.lastSeries()[1].Points[0][1]).To(Equal(int64(1)))
+		Expect(httpClient.lastSeries()[1].Tags).To(ConsistOf("job_name:job-name"))
+	})
+})
+
+func point(metric string) datadogreporter.Point {
+	return datadogreporter.Point{
+		Metric: metric,
+		Points: [][]int64{{1234, 4321}},
+		Type:   "gauge",
+		Tags:   []string{"event_type:logs", "job_name:job-name"},
+	}
+}
+
+type flakyHTTPClient struct {
+	mu         sync.Mutex
+	failures   int
+	failStatus int
+	failErr    error
+	rejected   string
+	_successes int
+	_series    []datadogreporter.Point
+	_accepted  []string
+	_requests  []recordedRequest
+}
+
+type recordedRequest struct {
+	url    string
+	header http.Header
+	body   []byte
+}
+
+func (s *flakyHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.failures > 0 {
+		s.failures--
+		if s.failErr != nil && s.failures%2 == 0 {
+			return nil, s.failErr
+		}
+
+		status := s.failStatus
+		if status == 0 {
+			status = 500
+		}
+		return &http.Response{StatusCode: status, Body: ioutil.NopCloser(strings.NewReader("error"))}, nil
+	}
+
+	var r io.Reader = req.Body
+	if req.Header.Get("Content-Encoding") == "gzip" {
+		var err error
+		r, err = gzip.NewReader(req.Body)
+		Expect(err).ToNot(HaveOccurred())
+	}
+	data, err := ioutil.ReadAll(r)
+	Expect(err).ToNot(HaveOccurred())
+
+	s._requests = append(s._requests, recordedRequest{
+		url:    req.URL.String(),
+		header: req.Header,
+		body:   data,
+	})
+
+	var body struct {
+		Series []datadogreporter.Point `json:"series"`
+	}
+	if json.Unmarshal(data, &body) != nil {
+		body.Series = nil
+	}
+
+	if s.rejected != "" {
+		for _, p := range body.Series {
+			if p.Metric == s.rejected {
+				return &http.Response{StatusCode: 400, Body: ioutil.NopCloser(strings.NewReader("bad series"))}, nil
+			}
+		}
+	}
+
+	s._series = body.Series
+	for _, p := range body.Series {
+		s._accepted = append(s._accepted, p.Metric)
+	}
+	s._successes++
+
+	return &http.Response{StatusCode: 202, Body: &spyReadCloser{}}, nil
+}
+
+// failWith sets the status, and an error to alternate with it, for the
+// failures set with setFailures.
+func (s *flakyHTTPClient) failWith(status int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.failStatus = status
+	s.failErr = err
+}
+
+// reject responds with 400 to any batch containing the metric.
+func (s *flakyHTTPClient) reject(metric string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.rejected = metric
+}
+
+// acceptedMetrics returns the metrics of every accepted series in order.
+func (s *flakyHTTPClient) acceptedMetrics() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s._accepted
+}
+
+func (s *flakyHTTPClient) requests() []recordedRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s._requests
+}
+
+func (s *flakyHTTPClient) setFailures(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.failures = n
+}
+
+func (s *flakyHTTPClient) successes() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s._successes
+}
+
+func (s *flakyHTTPClient) lastSeries() []datadogreporter.Point {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s._series
+}
+
+func (s *flakyHTTPClient) lastMetrics() []string {
+	var metrics []string
+	for _, p := range s.lastSeries() {
+		metrics = append(metrics, p.Metric)
+	}
+
+	return metrics
+}
+
+type blockingHTTPClient struct {
+	started chan []string
+	release chan struct{}
+}
+
+func newBlockingHTTPClient() *blockingHTTPClient {
+	return &blockingHTTPClient{
+		started: make(chan []string, 10),
+		release: make(chan struct{}),
+	}
+}
+
+// Do reports the metrics of each request and blocks until release is
+// closed.
+func (s *blockingHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	var body struct {
+		Series []datadogreporter.Point `json:"series"`
+	}
+	Expect(json.NewDecoder(req.Body).Decode(&body)).To(Succeed())
+
+	var metrics []string
+	for _, p := range body.Series {
+		metrics = append(metrics, p.Metric)
+	}
+	s.started <- metrics
+
+	<-s.release
+	return &http.Response{StatusCode: 202, Body: &spyReadCloser{}}, nil
+}
@@ -0,0 +1,171 @@
+package datadogreporter
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// PrometheusSink accumulates the counts it is given into monotonic counters
+// and exposes them in the Prometheus text exposition format. Absolute points
+// are exposed as gauges holding their latest value.
+type PrometheusSink struct {
+	mu     sync.Mutex
+	series map[string]*promSeries
+}
+
+type promSeries struct {
+	name   string
+	labels string
+	value  int64
+	gauge  bool
+}
+
+func NewPrometheusSink() *PrometheusSink {
+	return &PrometheusSink{
+		series: make(map[string]*promSeries),
+	}
+}
+
+// ListenAndServe serves the sink's counters on /metrics at addr.
+func (s *PrometheusSink) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", s)
+
+	go func() {
+		err := http.Serve(l, mux)
+		if err != nil {
+			log.Printf("prometheus endpoint stopped: %s", err)
+		}
+	}()
+
+	return nil
+}
+
+func (s *PrometheusSink) Write(points []Point) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, p := range points {
+		name := promName(p.Metric)
+		if !p.Absolute {
+			name += "_total"
+		}
+		labels := promLabels(p)
+		key := name + labels
+
+		ps, ok := s.series[key]
+		if !ok {
+			ps = &promSeries{name: name, labels: labels, gauge: p.Absolute}
+			s.series[key] = ps
+		}
+
+		for _, v := range p.Points {
+			if len(v) != 2 {
+				continue
+			}
+
+			if p.Absolute {
+				ps.value = v[1]
+			} else {
+				ps.value += v[1]
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s *PrometheusSink) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	s.writeTo(w)
+}
+
+func (s *PrometheusSink) writeTo(w io.Writer) {
+	s.mu.Lock()
+	series := make([]promSeries, 0, len(s.series))
+	for _, ps := range s.series {
+		series = append(series, *ps)
+	}
+	s.mu.Unlock()
+
+	sort.Slice(series, func(i, j int) bool {
+		if series[i].name != series[j].name {
+			return series[i].name < series[j].name
+		}
+		return series[i].labels < series[j].labels
+	})
+
+	var lastName string
+	for _, ps := range series {
+		if ps.name != lastName {
+			metricType := "counter"
+			if ps.gauge {
+				metricType = "gauge"
+			}
+			fmt.Fprintf(w, "# TYPE %s %s\n", ps.name, metricType)
+			lastName = ps.name
+		}
+		fmt.Fprintf(w, "%s%s %d\n", ps.name, ps.labels, ps.value)
+	}
+}
+
+// labelValueEscaper escapes label values as the Prometheus text format
+// requires.
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// promLabels converts the point's host and key:value tags into a sorted
+// Prometheus label set. Tags without a value become labels with an empty
+// value.
+func promLabels(p Point) string {
+	labels := make(map[string]string)
+	if p.Host != "" {
+		labels["host"] = p.Host
+	}
+
+	for _, t := range p.Tags {
+		parts := strings.SplitN(t, ":", 2)
+		if len(parts) != 2 {
+			parts = append(parts, "")
+		}
+		labels[promName(parts[0])] = parts[1]
+	}
+
+	if len(labels) == 0 {
+		return ""
+	}
+
+	names := make([]string, 0, len(labels))
+	for n := range labels {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	pairs := make([]string, 0, len(names))
+	for _, n := range names {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, n, labelValueEscaper.Replace(labels[n])))
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func promName(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+			return r
+		default:
+			return '_'
+		}
+	}, s)
+}
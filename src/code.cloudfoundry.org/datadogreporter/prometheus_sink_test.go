@@ -0,0 +1,118 @@
+package datadogreporter_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http/httptest"
+	"time"
+
+	"code.cloudfoundry.org/datadogreporter"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("PrometheusSink", func() {
+	It("exposes counts as monotonic counters and levels as gauges", func() {
+		s := datadogreporter.NewPrometheusSink()
+
+		for i := 0; i < 2; i++ {
+			err := s.Write([]datadogreporter.Point{
+				{
+					Metric: "capacity_planning.sent",
+					Points: [][]int64{{1234, 10}},
+					Type:   "gauge",
+					Host:   "abcdefg",
+					Tags:   []string{"event_type:logs", "job_name:job-name", "app-name"},
+				},
+				{
+					Metric: "capacity_planning.received",
+					Points: [][]int64{{1234, 7}},
+					Type:   "gauge",
+					Tags:   []string{"event_type:logs"},
+				},
+				{
+					Metric:   "capacity_planning.target_rate",
+					Points:   [][]int64{{1234, 100 + int64(i)}},
+					Type:     "gauge",
+					Absolute: true,
+				},
+			})
+			Expect(err).ToNot(HaveOccurred())
+		}
+
+		recorder := httptest.NewRecorder()
+		s.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+
+		body, err := ioutil.ReadAll(recorder.Body)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(body)).To(Equal(
+			"# TYPE capacity_planning_received_total counter\n" +
+				"capacity_planning_received_total{event_type=\"logs\"} 14\n" +
+				"# TYPE capacity_planning_sent_total counter\n" +
+				"capacity_planning_sent_total{app_name=\"\",event_type=\"logs\",host=\"abcdefg\",job_name=\"job-name\"} 20\n" +
+				"# TYPE capacity_planning_target_rate gauge\n" +
+				"capacity_planning_target_rate 101\n",
+		))
+	})
+
+	It("escapes label values", func() {
+		s := datadogreporter.NewPrometheusSink()
+
+		err := s.Write([]datadogreporter.Point{
+			{
+				Metric: "capacity_planning.sent",
+				Points: [][]int64{{1234, 1}},
+				Type:   "gauge",
+				Tags:   []string{"path:C:\\logs", "quote:say \"hi\"", "lines:a\nb", "unicode:café\t"},
+			},
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		recorder := httptest.NewRecorder()
+		s.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+
+		body, err := ioutil.ReadAll(recorder.Body)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(body)).To(ContainSubstring(
+			`capacity_planning_sent_total{lines="a\nb",path="C:\\logs",quote="say \"hi\"",unicode="café` + "\t" + `"} 1`,
+		))
+	})
+
+	It("exposes per-second summaries as gauges", func() {
+		s := datadogreporter.NewPrometheusSink()
+		reporter := datadogreporter.New(
+			"",
+			"job-name",
+			"instance-id",
+			&samplesPointBuilder{samples: [][]int64{{10, 4}, {11, 1}, {12, 2}, {13, 3}}},
+			datadogreporter.WithInterval(15*time.Minute),
+			datadogreporter.WithSink(s),
+			datadogreporter.WithResolution(datadogreporter.ResolutionSummary),
+		)
+
+		for i := 0; i < 2; i++ {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			reporter.Run(ctx)
+		}
+
+		recorder := httptest.NewRecorder()
+		s.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+
+		body, err := ioutil.ReadAll(recorder.Body)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(body)).To(Equal(
+			"# TYPE capacity_planning_sent_per_second_max gauge\n" +
+				"capacity_planning_sent_per_second_max{instance_index=\"instance-id\",job_name=\"job-name\"} 4\n" +
+				"# TYPE capacity_planning_sent_per_second_min gauge\n" +
+				"capacity_planning_sent_per_second_min{instance_index=\"instance-id\",job_name=\"job-name\"} 1\n" +
+				"# TYPE capacity_planning_sent_per_second_p50 gauge\n" +
+				"capacity_planning_sent_per_second_p50{instance_index=\"instance-id\",job_name=\"job-name\"} 2\n" +
+				"# TYPE capacity_planning_sent_per_second_p99 gauge\n" +
+				"capacity_planning_sent_per_second_p99{instance_index=\"instance-id\",job_name=\"job-name\"} 4\n" +
+				"# TYPE capacity_planning_sent_total counter\n" +
+				"capacity_planning_sent_total{instance_index=\"instance-id\",job_name=\"job-name\"} 20\n",
+		))
+	})
+})
@@ -0,0 +1,153 @@
+package datadogreporter
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Sink receives the points built by a DatadogReporter on every interval.
+type Sink interface {
+	Write(points []Point) error
+}
+
+// SinkConfig describes the sinks that points are written to.
+type SinkConfig struct {
+	// Sinks is a comma separated list of sink specs. Supported specs are
+	// "datadog", "statsd:<addr>", "stdout", "file:<path>" and
+	// "prometheus:<addr>".
+	Sinks string
+
+	// APIKey is required by the datadog sink.
+	APIKey string
+
+	// Site is the Datadog site to post to, e.g. datadoghq.eu. The default
+	// is the US1 site.
+	Site string
+
+	// APIVersion selects the v1 or v2 series API. The default is v1.
+	APIVersion string
+
+	// Compress gzips requests to Datadog.
+	Compress bool
+
+	// SpoolPath is a file where the datadog sink keeps series that have
+	// not yet been submitted, so that they survive a restart.
+	SpoolPath string
+
+	// MaxPendingSeries bounds the number of series the datadog sink holds
+	// for retry. Zero uses the default.
+	MaxPendingSeries int
+}
+
+// NewSink builds the sinks described by the config. When more than one sink
+// is configured, points are written to all of them.
+func NewSink(c SinkConfig) (Sink, error) {
+	var sinks MultiSink
+	for _, spec := range strings.Split(c.Sinks, ",") {
+		spec = strings.TrimSpace(spec)
+
+		switch {
+		case spec == "datadog":
+			if _, ok := payloadLimits[c.APIVersion]; c.APIVersion != "" && !ok {
+				return nil, fmt.Errorf("unknown datadog API version %q", c.APIVersion)
+			}
+			sinks = append(sinks, NewDatadogSink(
+				c.APIKey,
+				newHTTPClient(),
+				WithSite(c.Site),
+				WithAPIVersion(c.APIVersion),
+				WithCompression(c.Compress),
+				WithSpoolPath(c.SpoolPath),
+				WithMaxPendingSeries(c.MaxPendingSeries),
+			))
+		case strings.HasPrefix(spec, "statsd:"):
+			s, err := NewStatsDSink(strings.TrimPrefix(spec, "statsd:"))
+			if err != nil {
+				return nil, err
+			}
+			sinks = append(sinks, s)
+		case spec == "stdout":
+			sinks = append(sinks, NewWriterSink(os.Stdout))
+		case strings.HasPrefix(spec, "file:"):
+			s, err := NewFileSink(strings.TrimPrefix(spec, "file:"))
+			if err != nil {
+				return nil, err
+			}
+			sinks = append(sinks, s)
+		case strings.HasPrefix(spec, "prometheus:"):
+			s := NewPrometheusSink()
+			err := s.ListenAndServe(strings.TrimPrefix(spec, "prometheus:"))
+			if err != nil {
+				return nil, err
+			}
+			sinks = append(sinks, s)
+		default:
+			return nil, fmt.Errorf("unknown sink %q", spec)
+		}
+	}
+
+	if len(sinks) == 1 {
+		return sinks[0], nil
+	}
+
+	return sinks, nil
+}
+
+// WriterSink writes each point as a line of JSON.
+type WriterSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewWriterSink(w io.Writer) *WriterSink {
+	return &WriterSink{w: w}
+}
+
+// NewFileSink appends points to the file at path, creating it if necessary.
+func NewFileSink(path string) (*WriterSink, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewWriterSink(f), nil
+}
+
+func (s *WriterSink) Write(points []Point) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	enc := json.NewEncoder(s.w)
+	for _, p := range points {
+		err := enc.Encode(p)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// MultiSink writes points to every sink it contains.
+type MultiSink []Sink
+
+func (m MultiSink) Write(points []Point) error {
+	var errs []string
+	for _, s := range m {
+		err := s.Write(points)
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+
+	return nil
+}
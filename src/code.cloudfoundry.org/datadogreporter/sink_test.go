@@ -0,0 +1,115 @@
+package datadogreporter_test
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"code.cloudfoundry.org/datadogreporter"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Sinks", func() {
+	var points = []datadogreporter.Point{
+		{
+			Metric: "capacity_planning.sent",
+			Points: [][]int64{{1234, 4321}},
+			Type:   "gauge",
+			Host:   "abcdefg",
+			Tags:   []string{"event_type:logs"},
+		},
+		{
+			Metric: "capacity_planning.received",
+			Points: [][]int64{{1234, 4320}},
+			Type:   "gauge",
+			Host:   "abcdefg",
+			Tags:   []string{"event_type:logs"},
+		},
+	}
+
+	Describe("WriterSink", func() {
+		It("writes each point as a line of JSON", func() {
+			buf := &bytes.Buffer{}
+			s := datadogreporter.NewWriterSink(buf)
+
+			Expect(s.Write(points)).To(Succeed())
+
+			lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+			Expect(lines).To(HaveLen(2))
+			Expect(lines[0]).To(MatchJSON(`{
+				"metric": "capacity_planning.sent",
+				"points": [[1234, 4321]],
+				"type": "gauge",
+				"host": "abcdefg",
+				"tags": ["event_type:logs"]
+			}`))
+			Expect(lines[1]).To(MatchJSON(`{
+				"metric": "capacity_planning.received",
+				"points": [[1234, 4320]],
+				"type": "gauge",
+				"host": "abcdefg",
+				"tags": ["event_type:logs"]
+			}`))
+		})
+	})
+
+	Describe("MultiSink", func() {
+		It("writes to every sink and reports their errors", func() {
+			first := &spySink{}
+			second := &spySink{err: errors.New("an error")}
+
+			err := datadogreporter.MultiSink{first, second}.Write(points)
+			Expect(err).To(MatchError("an error"))
+
+			Expect(first.writes()).To(Equal([][]datadogreporter.Point{points}))
+			Expect(second.writes()).To(Equal([][]datadogreporter.Point{points}))
+		})
+	})
+
+	Describe("NewSink", func() {
+		It("builds a file sink", func() {
+			dir, err := ioutil.TempDir("", "sink")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.RemoveAll(dir)
+			path := filepath.Join(dir, "points.jsonl")
+
+			s, err := datadogreporter.NewSink(datadogreporter.SinkConfig{
+				Sinks: "file:" + path,
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(s.Write(points)).To(Succeed())
+
+			data, err := ioutil.ReadFile(path)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(bytes.Count(data, []byte("\n"))).To(Equal(2))
+		})
+
+		It("builds multiple sinks", func() {
+			s, err := datadogreporter.NewSink(datadogreporter.SinkConfig{
+				Sinks:  "datadog, stdout",
+				APIKey: "api-key",
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(s).To(HaveLen(2))
+		})
+
+		It("builds the datadog sink without an API key", func() {
+			s, err := datadogreporter.NewSink(datadogreporter.SinkConfig{
+				Sinks: "datadog",
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(s).To(BeAssignableToTypeOf(&datadogreporter.DatadogSink{}))
+		})
+
+		It("rejects unknown sinks", func() {
+			_, err := datadogreporter.NewSink(datadogreporter.SinkConfig{
+				Sinks: "carrier-pigeon",
+			})
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1,107 @@
+package datadogreporter
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+)
+
+// maxDatagramSize keeps packets under the common network MTU so that a
+// local agent receives them unfragmented.
+const maxDatagramSize = 1432
+
+// StatsDSink sends points over UDP to a local DogStatsD agent. Counts are
+// summed into a single counter per point, since the agent stamps every
+// value with the time it arrives and would keep only the last of several
+// gauges in a flush interval. Absolute points are sent as gauges of their
+// latest value. The agent supplies the API key and host, so neither is
+// sent.
+type StatsDSink struct {
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func NewStatsDSink(addr string) (*StatsDSink, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &StatsDSink{conn: conn}, nil
+}
+
+func (s *StatsDSink) Write(points []Point) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var buf bytes.Buffer
+	for _, p := range points {
+		line, ok := statsDLine(p)
+		if !ok {
+			continue
+		}
+
+		if buf.Len() > 0 && buf.Len()+len(line)+1 > maxDatagramSize {
+			err := s.flush(&buf)
+			if err != nil {
+				return err
+			}
+		}
+
+		if buf.Len() > 0 {
+			buf.WriteByte('\n')
+		}
+		buf.WriteString(line)
+	}
+
+	return s.flush(&buf)
+}
+
+func (s *StatsDSink) flush(buf *bytes.Buffer) error {
+	if buf.Len() == 0 {
+		return nil
+	}
+	defer buf.Reset()
+
+	_, err := s.conn.Write(buf.Bytes())
+	return err
+}
+
+// statsDLine returns a counter of the sum of the point's values, or a gauge
+// of its latest value if it is absolute. It returns false if the point has
+// no values.
+func statsDLine(p Point) (string, bool) {
+	var (
+		value int64
+		found bool
+	)
+	for _, v := range p.Points {
+		if len(v) != 2 {
+			continue
+		}
+		found = true
+
+		if p.Absolute {
+			value = v[1]
+		} else {
+			value += v[1]
+		}
+	}
+	if !found {
+		return "", false
+	}
+
+	kind := "c"
+	if p.Absolute {
+		kind = "g"
+	}
+
+	line := fmt.Sprintf("%s:%d|%s", p.Metric, value, kind)
+	if len(p.Tags) > 0 {
+		line += "|#" + strings.Join(p.Tags, ",")
+	}
+
+	return line, true
+}
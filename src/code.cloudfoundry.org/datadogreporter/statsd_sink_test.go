@@ -0,0 +1,70 @@
+package datadogreporter_test
+
+import (
+	"net"
+
+	"code.cloudfoundry.org/datadogreporter"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("StatsDSink", func() {
+	var (
+		agent *net.UDPConn
+	)
+
+	BeforeEach(func() {
+		addr, err := net.ResolveUDPAddr("udp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+
+		agent, err = net.ListenUDP("udp", addr)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		agent.Close()
+	})
+
+	It("sends counts as DogStatsD counters and absolute points as gauges", func() {
+		s, err := datadogreporter.NewSink(datadogreporter.SinkConfig{
+			Sinks: "statsd:" + agent.LocalAddr().String(),
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		err = s.Write([]datadogreporter.Point{
+			{
+				Metric: "capacity_planning.sent",
+				Points: [][]int64{{1234, 4321}, {1235, 1000}, {1236, 0}},
+				Type:   "gauge",
+				Host:   "abcdefg",
+				Tags:   []string{"event_type:logs", "job_name:job-name", "instance_index:0"},
+			},
+			{
+				Metric: "capacity_planning.received",
+				Points: [][]int64{{1234, 4320}},
+				Type:   "gauge",
+			},
+			{
+				Metric: "capacity_planning.lost",
+				Type:   "gauge",
+			},
+			{
+				Metric:   "capacity_planning.target_rate",
+				Points:   [][]int64{{1234, 100}, {1235, 200}},
+				Type:     "gauge",
+				Absolute: true,
+			},
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		buf := make([]byte, 2048)
+		n, err := agent.Read(buf)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(buf[:n])).To(Equal(
+			"capacity_planning.sent:5321|c|#event_type:logs,job_name:job-name,instance_index:0\n" +
+				"capacity_planning.received:4320|c\n" +
+				"capacity_planning.target_rate:200|g",
+		))
+	})
+})
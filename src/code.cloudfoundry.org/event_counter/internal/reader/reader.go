@@ -1,12 +1,13 @@
 package reader
 
 import (
+	"context"
 	"crypto/tls"
 	"log"
-	"sync/atomic"
 	"time"
 
 	"code.cloudfoundry.org/authenticator"
+	"code.cloudfoundry.org/backoff"
 	"code.cloudfoundry.org/datadogreporter"
 
 	"github.com/cloudfoundry/noaa/consumer"
@@ -19,8 +20,11 @@ type Reader struct {
 	subscriptionID string
 	counterOrigin  string
 	tlsConfig      *tls.Config
-	logCount       int64
-	metricCount    int64
+	backoff        *backoff.Backoff
+	logCount       datadogreporter.Counter
+	metricCount    datadogreporter.Counter
+	authFailures   datadogreporter.Counter
+	reconnects     datadogreporter.Counter
 }
 
 func New(
@@ -36,73 +40,94 @@ func New(
 		subscriptionID: subscriptionID,
 		counterOrigin:  counterOrigin,
 		tlsConfig:      tlsConfig,
+		backoff:        backoff.New(time.Second, 2*time.Minute),
 	}
 }
 
-func (r *Reader) Run() {
+// Run reads from the firehose until the context is done, reconnecting with
+// backoff whenever the connection fails.
+func (r *Reader) Run(ctx context.Context) {
 	for {
 		authToken, err := r.a.Token()
 		if err != nil {
+			r.authFailures.Add(1)
+			if authenticator.IsCredentialsError(err) {
+				r.backoff.Max()
+			}
 			log.Printf("failed to authenticate with UAA: %s", err)
-			time.Sleep(time.Second)
-			continue
+		} else if r.read(ctx, authToken) {
+			r.backoff.Reset()
 		}
 
-		r.read(authToken)
+		if ctx.Err() != nil || !r.backoff.Wait(ctx) {
+			return
+		}
+		r.reconnects.Add(1)
 	}
 }
 
-func (r *Reader) BuildPoints() []datadogreporter.Point {
-	logs := atomic.SwapInt64(&r.logCount, 0)
-	metrics := atomic.SwapInt64(&r.metricCount, 0)
-
-	currentTime := time.Now().Unix()
-
+func (r *Reader) BuildPoints(w datadogreporter.Window) []datadogreporter.Point {
 	return []datadogreporter.Point{
 		{
 			Metric: "capacity_planning.received",
-			Points: [][]int64{
-				[]int64{currentTime, logs},
-			},
-			Type: "gauge",
+			Points: r.logCount.Samples(w),
+			Type:   "gauge",
 			Tags: []string{
 				"event_type:logs",
 			},
 		},
 		{
 			Metric: "capacity_planning.received",
-			Points: [][]int64{
-				[]int64{currentTime, metrics},
-			},
-			Type: "gauge",
+			Points: r.metricCount.Samples(w),
+			Type:   "gauge",
 			Tags: []string{
 				"event_type:metrics",
 			},
 		},
+		{
+			Metric: "capacity_planning.reader.auth_failures",
+			Points: r.authFailures.Samples(w),
+			Type:   "gauge",
+		},
+		{
+			Metric: "capacity_planning.reader.reconnects",
+			Points: r.reconnects.Samples(w),
+			Type:   "gauge",
+		},
 	}
 }
 
-func (r *Reader) read(authToken string) {
+// read counts envelopes until the connection fails or the context is done.
+// It returns true if any envelopes were received.
+func (r *Reader) read(ctx context.Context, authToken string) bool {
 	cmr := consumer.New(r.egressAddr, r.tlsConfig, nil)
 
+	cmr.RefreshTokenFrom(r.a)
+
 	msgChan, errChan := cmr.FirehoseWithoutReconnect(r.subscriptionID, authToken)
 
+	var received bool
 	for {
 		select {
+		case <-ctx.Done():
+			cmr.Close()
+			return received
 		case err := <-errChan:
 			if err != nil {
 				log.Println(err)
 			}
 
-			return
+			return received
 		case msg := <-msgChan:
+			received = true
+
 			if msg.GetEventType() == events.Envelope_LogMessage {
-				atomic.AddInt64(&r.logCount, 1)
+				r.logCount.Add(1)
 			}
 
 			if msg.GetEventType() == events.Envelope_CounterEvent {
 				if msg.GetOrigin() == r.counterOrigin {
-					atomic.AddInt64(&r.metricCount, 1)
+					r.metricCount.Add(1)
 				}
 			}
 		}
@@ -1,34 +1,28 @@
 package main
 
 import (
-	"crypto/tls"
 	"flag"
 	"log"
-	"net/http"
 	"strings"
 	"time"
 
 	"code.cloudfoundry.org/authenticator"
 	"code.cloudfoundry.org/datadogreporter"
 	"code.cloudfoundry.org/event_counter/internal/reader"
-)
-
-var (
-	tlsConfig *tls.Config = &tls.Config{
-		InsecureSkipVerify: true,
-	}
-
-	httpClient *http.Client = &http.Client{
-		Timeout: 30 * time.Second,
-		Transport: &http.Transport{
-			TLSClientConfig: tlsConfig,
-		},
-	}
+	"code.cloudfoundry.org/shutdown"
+	"code.cloudfoundry.org/tlsclient"
 )
 
 func main() {
 	loggregatorEgressURL := flag.String("loggregator-egress-url", "", "Websocket URL for Loggregator egress.")
 	datadogAPIKey := flag.String("datadog-api-key", "", "Datadog API key.")
+	sinks := flag.String("sink", "datadog", "Comma separated list of sinks for metrics: datadog, statsd:<addr>, stdout, file:<path> or prometheus:<addr>.")
+	spoolPath := flag.String("datadog-spool-path", "", "File for holding Datadog series that have not been submitted.")
+	datadogSite := flag.String("datadog-site", "", "Datadog site to send metrics to, e.g. datadoghq.eu. Defaults to the US1 site.")
+	datadogAPIVersion := flag.String("datadog-api-version", "v1", "Version of the Datadog series API: v1 or v2.")
+	datadogCompress := flag.Bool("datadog-compress", false, "Gzip requests to Datadog.")
+	drainTimeout := flag.Duration("drain-timeout", 5*time.Second, "Time to keep counting after emitters stop on shutdown.")
+	resolution := flag.String("report-resolution", "interval", "How per-second counts are reported: interval, second or summary.")
 	subscriptionID := flag.String("subscription-id", "capacity-planning", "The firehose subscription ID")
 	counterOrigin := flag.String("counter-origin", "", "Count only metrics from exactly this origin.")
 
@@ -38,6 +32,18 @@ func main() {
 	uaaAddr := flag.String("uaa-addr", "", "The URL for UAA")
 	clientID := flag.String("client-id", "", "ID of client used for authentication.")
 	clientSecret := flag.String("client-secret", "", "Secret used for authentication.")
+	username := flag.String("username", "", "User to authenticate as with the password grant instead of client credentials.")
+	password := flag.String("password", "", "Password for the user.")
+	refreshToken := flag.String("refresh-token", "", "Refresh token to authenticate with instead of client credentials.")
+	authToken := flag.String("auth-token", "", "Bearer token to use instead of authenticating with UAA. It is never refreshed.")
+
+	var tlsFlags tlsclient.Config
+	flag.StringVar(&tlsFlags.CAPath, "ca-path", "", "Path to CA certificates for verifying UAA and Loggregator egress. Defaults to the system pool.")
+	flag.StringVar(&tlsFlags.CertPath, "cert-path", "", "Path to a client certificate to present to servers.")
+	flag.StringVar(&tlsFlags.KeyPath, "key-path", "", "Path to the private key for the client certificate.")
+	flag.BoolVar(&tlsFlags.Insecure, "skip-ssl-validation", false, "Do not verify server certificates. Insecure.")
+	flag.StringVar(&tlsFlags.UAAServerName, "uaa-server-name", "", "Server name to verify UAA's certificate against instead of its host name.")
+	flag.StringVar(&tlsFlags.LoggregatorServerName, "loggregator-server-name", "", "Server name to verify Loggregator egress's certificate against instead of its host name.")
 	flag.Parse()
 
 	var missing []string
@@ -45,10 +51,6 @@ func main() {
 		missing = append(missing, "loggregator-egress-url")
 	}
 
-	if *datadogAPIKey == "" {
-		missing = append(missing, "datadog-api-key")
-	}
-
 	if *subscriptionID == "" {
 		missing = append(missing, "subscription-id")
 	}
@@ -65,45 +67,110 @@ func main() {
 		missing = append(missing, "instance-id")
 	}
 
-	if *uaaAddr == "" {
-		missing = append(missing, "uaa-addr")
+	grant := authenticator.WithClientCredentialsGrant()
+	switch {
+	case *authToken != "":
+		grant = authenticator.WithStaticToken(*authToken)
+	case *refreshToken != "":
+		grant = authenticator.WithRefreshTokenGrant(*refreshToken)
+	case *username != "":
+		grant = authenticator.WithPasswordGrant(*username, *password)
+	default:
+		if *clientID == "" {
+			missing = append(missing, "client-id")
+		}
+
+		if *clientSecret == "" {
+			missing = append(missing, "client-secret")
+		}
 	}
 
-	if *clientID == "" {
-		missing = append(missing, "client-id")
+	if *uaaAddr == "" && *authToken == "" {
+		missing = append(missing, "uaa-addr")
 	}
 
-	if *clientSecret == "" {
-		missing = append(missing, "client-secret")
+	if *clientID == "" {
+		*clientID = "cf"
 	}
 
 	if len(missing) > 0 {
 		log.Fatalf("missing required flags: %s", strings.Join(missing, ", "))
 	}
 
+	tlsConfigs, err := tlsclient.NewConfigs(tlsFlags)
+	if err != nil {
+		log.Fatalf("failed to build TLS config: %s", err)
+	}
+	if tlsFlags.Insecure {
+		log.Println("WARNING: server certificates will not be verified")
+	}
+
 	auth := authenticator.New(
 		*clientID,
 		*clientSecret,
 		*uaaAddr,
-		authenticator.WithHTTPClient(httpClient),
+		authenticator.WithTLSConfig(tlsConfigs.UAA),
+		grant,
 	)
+	checkToken(auth)
 
 	reader := reader.New(
 		auth,
 		*loggregatorEgressURL,
 		*subscriptionID,
 		*counterOrigin,
-		tlsConfig,
+		tlsConfigs.Loggregator,
 	)
 
+	sink, err := datadogreporter.NewSink(datadogreporter.SinkConfig{
+		Sinks:      *sinks,
+		APIKey:     *datadogAPIKey,
+		SpoolPath:  *spoolPath,
+		Site:       *datadogSite,
+		APIVersion: *datadogAPIVersion,
+		Compress:   *datadogCompress,
+	})
+	if err != nil {
+		log.Fatalf("failed to create metrics sink: %s", err)
+	}
+
 	reporter := datadogreporter.New(
 		*datadogAPIKey,
 		*jobName,
 		*instanceID,
 		reader,
+		datadogreporter.WithSink(sink),
+		datadogreporter.WithResolution(*resolution),
 	)
 
-	go reader.Run()
+	_, ctx := shutdown.Contexts(*drainTimeout)
+
+	go reader.Run(ctx)
 
-	reporter.Run()
+	reporter.Run(ctx)
+}
+
+// checkToken fails fast when the token cannot be used to read the firehose. Tokens
+// that cannot be decoded, or fetched yet, are left to the reader to retry.
+func checkToken(auth *authenticator.Authenticator) {
+	claims, err := auth.Claims()
+	if err != nil {
+		log.Printf("unable to check token scopes: %s", err)
+		return
+	}
+
+	log.Printf("Authenticated with scopes [%s], audience [%s], expiring at %s",
+		strings.Join(claims.Scopes, " "),
+		strings.Join(claims.Audience, " "),
+		claims.ExpiresAt,
+	)
+
+	if claims.Expired() {
+		log.Fatalf("token expired at %s", claims.ExpiresAt)
+	}
+
+	err = claims.RequireAnyScope("doppler.firehose", "logs.admin")
+	if err != nil {
+		log.Fatalf("%s", err)
+	}
 }
@@ -3,23 +3,43 @@ package main
 import (
 	"context"
 	"crypto/tls"
+	"fmt"
 	"log"
+	"strconv"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
+	"code.cloudfoundry.org/control"
 	"code.cloudfoundry.org/datadogreporter"
 	envstruct "code.cloudfoundry.org/go-envstruct"
 	loggregator "code.cloudfoundry.org/go-loggregator"
+	"code.cloudfoundry.org/loadprofile"
+	"code.cloudfoundry.org/pacer"
+	"code.cloudfoundry.org/shutdown"
 )
 
 type Config struct {
-	EmitInterval  time.Duration `env:"EMIT_INTERVAL"`
-	EventTitle    string        `env:"EVENT_TITLE"`
-	EventBody     string        `env:"EVENT_BODY"`
-	DatadogAPIKey string        `env:"DATADOG_API_KEY, required"`
-	JobName       string        `env:"JOB_NAME,        required"`
-	InstanceID    string        `env:"INSTANCE_ID,     required"`
-	Host          string        `env:"HOST,            required"`
+	EmitInterval   time.Duration `env:"EMIT_INTERVAL"`
+	LoadProfile    string        `env:"LOAD_PROFILE"`
+	ControlAddr    string        `env:"CONTROL_ADDR"`
+	ControlToken   string        `env:"CONTROL_TOKEN"`
+	ControlMaxRate float64       `env:"CONTROL_MAX_RATE"`
+	DrainTimeout   time.Duration `env:"DRAIN_TIMEOUT"`
+	Resolution     string        `env:"REPORT_RESOLUTION"`
+	EventTitle     string        `env:"EVENT_TITLE"`
+	EventBody      string        `env:"EVENT_BODY"`
+	DatadogAPIKey  string        `env:"DATADOG_API_KEY"`
+	Sinks          string        `env:"SINK"`
+	SpoolPath      string        `env:"DATADOG_SPOOL_PATH"`
+
+	DatadogSite       string `env:"DATADOG_SITE"`
+	DatadogAPIVersion string `env:"DATADOG_API_VERSION"`
+	DatadogCompress   bool   `env:"DATADOG_COMPRESS"`
+	JobName           string `env:"JOB_NAME,        required"`
+	InstanceID        string `env:"INSTANCE_ID,     required"`
+	Host              string `env:"HOST,            required"`
 
 	CAPath   string `env:"CA_PATH,   required"`
 	KeyPath  string `env:"KEY_PATH,  required"`
@@ -28,7 +48,11 @@ type Config struct {
 
 func main() {
 	cfg := Config{
-		EmitInterval: time.Second,
+		EmitInterval:   time.Second,
+		ControlMaxRate: 1000,
+		DrainTimeout:   5 * time.Second,
+		Resolution:     datadogreporter.ResolutionInterval,
+		Sinks:          "datadog",
 	}
 	err := envstruct.Load(&cfg)
 	if err != nil {
@@ -44,8 +68,40 @@ func main() {
 		log.Fatalf("failed to build TLS config: %s", err)
 	}
 
+	sink, err := datadogreporter.NewSink(datadogreporter.SinkConfig{
+		Sinks:      cfg.Sinks,
+		APIKey:     cfg.DatadogAPIKey,
+		SpoolPath:  cfg.SpoolPath,
+		Site:       cfg.DatadogSite,
+		APIVersion: cfg.DatadogAPIVersion,
+		Compress:   cfg.DatadogCompress,
+	})
+	if err != nil {
+		log.Fatalf("failed to create metrics sink: %s", err)
+	}
+
+	var profile loadprofile.Profile
+	if cfg.LoadProfile != "" {
+		profile, err = loadprofile.Parse(cfg.LoadProfile)
+		if err != nil {
+			log.Fatalf("invalid load profile: %s", err)
+		}
+	}
+
 	wr := newWriter(cfg.EmitInterval, cfg.EventTitle, cfg.EventBody, tlsConfig)
-	go wr.run()
+	emitCtx, countCtx := shutdown.Contexts(cfg.DrainTimeout)
+	go wr.run(emitCtx)
+
+	controller := newController(wr, cfg.ControlToken, cfg.ControlMaxRate)
+	if cfg.ControlAddr != "" {
+		err := controller.ListenAndServe(cfg.ControlAddr)
+		if err != nil {
+			log.Fatalf("failed to serve the control API: %s", err)
+		}
+	}
+	if profile != nil {
+		go loadprofile.Run(emitCtx, profile, controller, 100*time.Millisecond)
+	}
 
 	reporter := datadogreporter.New(
 		cfg.DatadogAPIKey,
@@ -53,16 +109,21 @@ func main() {
 		cfg.InstanceID,
 		wr,
 		datadogreporter.WithHost(cfg.Host),
+		datadogreporter.WithSink(sink),
+		datadogreporter.WithResolution(cfg.Resolution),
 	)
-	reporter.Run()
+	reporter.Run(countCtx)
 }
 
 type writer struct {
-	emitInterval time.Duration
-	client       *loggregator.IngressClient
-	eventCount   int64
-	title        string
-	body         string
+	sent       int64
+	pacer      *pacer.Pacer
+	client     *loggregator.IngressClient
+	eventCount datadogreporter.Counter
+
+	mu    sync.Mutex
+	title string
+	body  string
 }
 
 func newWriter(
@@ -76,39 +137,102 @@ func newWriter(
 		log.Fatalf("failed to create ingress client: %s", err)
 	}
 
+	var rate float64
+	if emitInterval > 0 {
+		rate = float64(time.Second) / float64(emitInterval)
+	}
+
 	return &writer{
-		emitInterval: emitInterval,
-		client:       c,
-		title:        title,
-		body:         body,
+		pacer:  pacer.New(rate),
+		client: c,
+		title:  title,
+		body:   body,
 	}
 }
 
-func (w *writer) run() {
-	t := time.NewTicker(w.emitInterval)
-	for range t.C {
-		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
-		err := w.client.EmitEvent(ctx, w.title, w.body)
-		cancel()
-		if err != nil {
-			log.Printf("failed to write event: %s", err)
-			continue
+func (w *writer) run(ctx context.Context) {
+	w.pacer.Run(ctx, func(n int) {
+		title, body := w.event()
+		for i := 0; i < n; i++ {
+			emitCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+			err := w.client.EmitEvent(emitCtx, title, body)
+			cancel()
+			if err != nil {
+				log.Printf("failed to write event: %s", err)
+				continue
+			}
+
+			atomic.AddInt64(&w.sent, 1)
+			w.eventCount.Add(1)
 		}
+	})
+}
 
-		atomic.AddInt64(&w.eventCount, 1)
-	}
+func (w *writer) event() (string, string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.title, w.body
 }
 
-func (w *writer) BuildPoints() []datadogreporter.Point {
-	count := atomic.SwapInt64(&w.eventCount, 0)
+// newController returns a controller for the writer's rate and event that
+// reports the number of events sent.
+func newController(w *writer, token string, maxRate float64) *control.Controller {
+	field := func(f *string) control.Setting {
+		return control.Setting{
+			Get: func() string {
+				w.mu.Lock()
+				defer w.mu.Unlock()
+				return *f
+			},
+			Set: func(v string) error {
+				w.mu.Lock()
+				defer w.mu.Unlock()
+				*f = v
+				return nil
+			},
+		}
+	}
+
+	bodyBytes := control.Setting{
+		Get: func() string {
+			w.mu.Lock()
+			defer w.mu.Unlock()
+			return strconv.Itoa(len(w.body))
+		},
+		Set: func(v string) error {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return fmt.Errorf("invalid body size %q", v)
+			}
 
-	return []datadogreporter.Point{
+			w.mu.Lock()
+			defer w.mu.Unlock()
+			w.body = strings.Repeat("x", n)
+			return nil
+		},
+	}
+
+	return control.New(w.pacer, w.pacer.Rate(),
+		control.WithToken(token),
+		control.WithMaxRate(maxRate),
+		control.WithSetting("event_title", field(&w.title)),
+		control.WithSetting("event_body", field(&w.body)),
+		control.WithSetting("event_body_bytes", bodyBytes),
+		control.WithCounts(func() map[string]int64 {
+			return map[string]int64{"sent": atomic.LoadInt64(&w.sent)}
+		}),
+	)
+}
+
+func (w *writer) BuildPoints(window datadogreporter.Window) []datadogreporter.Point {
+	points := []datadogreporter.Point{
 		{
 			Metric: "event_emitter.sent",
-			Points: [][]int64{
-				[]int64{time.Now().Unix(), count},
-			},
-			Type: "gauge",
+			Points: w.eventCount.Samples(window),
+			Type:   "gauge",
 		},
 	}
+
+	return append(points, datadogreporter.RatePoints("event_emitter.target_rate", window, w.pacer.Rate(), nil)...)
 }
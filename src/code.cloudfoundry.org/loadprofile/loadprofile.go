@@ -0,0 +1,286 @@
+// Package loadprofile describes how an emitter's target rate changes over
+// the lifetime of a test, so that a single deployment can find the rate at
+// which loss starts.
+package loadprofile
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Profile returns the target rate, in events per second, at a time since
+// the start of the test.
+type Profile interface {
+	Rate(elapsed time.Duration) float64
+}
+
+// Parse builds a profile from a spec, one of:
+//
+//	constant:<rate>
+//	ramp:<from>,<to>,<duration>            linear, then holds at <to>
+//	step:<start>,<increment>,<every>[,<max>]
+//	spike:<base>,<peak>,<every>,<length>   <peak> for <length> of every <every>
+//	sine:<mean>,<amplitude>,<period>
+//	schedule:<path>                        see LoadSchedule
+//
+// Durations use Go syntax, e.g. 90s or 10m.
+func Parse(spec string) (Profile, error) {
+	parts := strings.SplitN(spec, ":", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid load profile %q", spec)
+	}
+	kind, args := parts[0], strings.Split(parts[1], ",")
+
+	if kind == "schedule" {
+		return LoadSchedule(parts[1])
+	}
+
+	p := &params{args: args}
+	var profile Profile
+	switch kind {
+	case "constant":
+		profile = Constant(p.rate(0))
+	case "ramp":
+		profile = Ramp{From: p.rate(0), To: p.rate(1), Duration: p.duration(2)}
+	case "step":
+		s := Step{Start: p.rate(0), Increment: p.float(1), Every: p.duration(2), Max: math.Inf(1)}
+		if len(args) > 3 {
+			s.Max = p.rate(3)
+		}
+		profile = s
+	case "spike":
+		profile = Spike{Base: p.rate(0), Peak: p.rate(1), Every: p.duration(2), Length: p.duration(3)}
+	case "sine":
+		profile = Sine{Mean: p.rate(0), Amplitude: p.float(1), Period: p.duration(2)}
+	default:
+		return nil, fmt.Errorf("unknown load profile %q", kind)
+	}
+
+	if p.err != nil {
+		return nil, fmt.Errorf("invalid %s profile %q: %s", kind, parts[1], p.err)
+	}
+
+	return profile, nil
+}
+
+// params parses positional arguments, keeping the first error.
+type params struct {
+	args []string
+	err  error
+}
+
+func (p *params) arg(i int) string {
+	if i >= len(p.args) {
+		if p.err == nil {
+			p.err = fmt.Errorf("expected at least %d arguments", i+1)
+		}
+		return ""
+	}
+
+	return strings.TrimSpace(p.args[i])
+}
+
+func (p *params) float(i int) float64 {
+	s := p.arg(i)
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil && p.err == nil {
+		p.err = fmt.Errorf("invalid number %q", s)
+	}
+
+	return v
+}
+
+func (p *params) rate(i int) float64 {
+	v := p.float(i)
+	if v < 0 && p.err == nil {
+		p.err = fmt.Errorf("negative rate %v", v)
+	}
+
+	return v
+}
+
+func (p *params) duration(i int) time.Duration {
+	s := p.arg(i)
+	d, err := time.ParseDuration(s)
+	if (err != nil || d <= 0) && p.err == nil {
+		p.err = fmt.Errorf("invalid duration %q", s)
+	}
+
+	return d
+}
+
+// Constant emits at the same rate throughout.
+type Constant float64
+
+func (c Constant) Rate(time.Duration) float64 {
+	return float64(c)
+}
+
+// Ramp changes linearly from From to To over Duration, then holds at To.
+type Ramp struct {
+	From     float64
+	To       float64
+	Duration time.Duration
+}
+
+func (r Ramp) Rate(elapsed time.Duration) float64 {
+	if elapsed >= r.Duration {
+		return r.To
+	}
+
+	return r.From + (r.To-r.From)*float64(elapsed)/float64(r.Duration)
+}
+
+// Step starts at Start and adds Increment every Every, up to Max.
+type Step struct {
+	Start     float64
+	Increment float64
+	Every     time.Duration
+	Max       float64
+}
+
+func (s Step) Rate(elapsed time.Duration) float64 {
+	rate := s.Start + s.Increment*float64(elapsed/s.Every)
+
+	return math.Max(math.Min(rate, s.Max), 0)
+}
+
+// Spike emits at Peak for Length at the start of every Every, and at Base
+// otherwise.
+type Spike struct {
+	Base   float64
+	Peak   float64
+	Every  time.Duration
+	Length time.Duration
+}
+
+func (s Spike) Rate(elapsed time.Duration) float64 {
+	if elapsed%s.Every < s.Length {
+		return s.Peak
+	}
+
+	return s.Base
+}
+
+// Sine oscillates around Mean by Amplitude with the given Period. Rates
+// below zero are clamped to zero.
+type Sine struct {
+	Mean      float64
+	Amplitude float64
+	Period    time.Duration
+}
+
+func (s Sine) Rate(elapsed time.Duration) float64 {
+	phase := 2 * math.Pi * float64(elapsed%s.Period) / float64(s.Period)
+
+	return math.Max(s.Mean+s.Amplitude*math.Sin(phase), 0)
+}
+
+// Schedule is a piecewise profile. Each entry sets the rate from its offset
+// until the next entry; an entry marked ramp instead changes linearly from
+// the previous entry's rate. The last rate holds for the rest of the test.
+type Schedule []ScheduleEntry
+
+type ScheduleEntry struct {
+	Offset time.Duration
+	Rate   float64
+	Ramp   bool
+}
+
+// LoadSchedule reads a schedule from a file with an offset and a rate on
+// each line, optionally followed by the word ramp:
+//
+//	0s   1000
+//	5m   5000 ramp
+//	10m  0
+//
+// Blank lines and lines starting with # are ignored.
+func LoadSchedule(path string) (Schedule, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var s Schedule
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.Fields(text)
+		if len(fields) < 2 || len(fields) > 3 || (len(fields) == 3 && fields[2] != "ramp") {
+			return nil, fmt.Errorf("%s:%d: expected <offset> <rate> [ramp]", path, line)
+		}
+
+		offset, err := time.ParseDuration(fields[0])
+		if err != nil || offset < 0 {
+			return nil, fmt.Errorf("%s:%d: invalid offset %q", path, line, fields[0])
+		}
+		rate, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil || rate < 0 {
+			return nil, fmt.Errorf("%s:%d: invalid rate %q", path, line, fields[1])
+		}
+
+		s = append(s, ScheduleEntry{Offset: offset, Rate: rate, Ramp: len(fields) == 3})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(s) == 0 {
+		return nil, fmt.Errorf("%s: schedule is empty", path)
+	}
+	sort.SliceStable(s, func(i, j int) bool { return s[i].Offset < s[j].Offset })
+
+	return s, nil
+}
+
+func (s Schedule) Rate(elapsed time.Duration) float64 {
+	i := sort.Search(len(s), func(i int) bool { return s[i].Offset > elapsed })
+	if i == 0 {
+		return 0
+	}
+
+	// Ramp towards the next entry if it is marked as a ramp.
+	if i < len(s) && s[i].Ramp {
+		prev, next := s[i-1], s[i]
+		frac := float64(elapsed-prev.Offset) / float64(next.Offset-prev.Offset)
+		return prev.Rate + (next.Rate-prev.Rate)*frac
+	}
+
+	return s[i-1].Rate
+}
+
+// Rater is anything whose target rate can be set, such as a pacer.
+type Rater interface {
+	SetRate(float64)
+}
+
+// Run sets the rate of r from the profile every tick until the context is
+// done. Time is measured from when Run is called.
+func Run(ctx context.Context, p Profile, r Rater, tick time.Duration) {
+	start := time.Now()
+	r.SetRate(p.Rate(0))
+
+	t := time.NewTicker(tick)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			r.SetRate(p.Rate(time.Since(start)))
+		}
+	}
+}
@@ -0,0 +1,13 @@
+package loadprofile_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestLoadprofile(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Loadprofile Suite")
+}
@@ -0,0 +1,100 @@
+package loadprofile_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/loadprofile"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Loadprofile", func() {
+	rates := func(spec string, at ...time.Duration) []float64 {
+		p, err := loadprofile.Parse(spec)
+		Expect(err).ToNot(HaveOccurred())
+
+		var rates []float64
+		for _, d := range at {
+			rates = append(rates, p.Rate(d))
+		}
+		return rates
+	}
+
+	It("holds a constant rate", func() {
+		Expect(rates("constant:500", 0, time.Hour)).To(Equal([]float64{500, 500}))
+	})
+
+	It("ramps linearly then holds", func() {
+		Expect(rates("ramp:100,1100,10m", 0, 5*time.Minute, 20*time.Minute)).To(Equal([]float64{100, 600, 1100}))
+	})
+
+	It("steps up to the max", func() {
+		Expect(rates("step:1000,500,1m,2000", 0, 90*time.Second, 2*time.Minute, time.Hour)).To(Equal([]float64{1000, 1500, 2000, 2000}))
+	})
+
+	It("spikes periodically", func() {
+		Expect(rates("spike:100,1000,1m,10s", 5*time.Second, 30*time.Second, 65*time.Second)).To(Equal([]float64{1000, 100, 1000}))
+	})
+
+	It("oscillates around the mean", func() {
+		r := rates("sine:1000,500,4m", 0, time.Minute, 3*time.Minute)
+		Expect(r[0]).To(BeNumerically("~", 1000, 0.001))
+		Expect(r[1]).To(BeNumerically("~", 1500, 0.001))
+		Expect(r[2]).To(BeNumerically("~", 500, 0.001))
+	})
+
+	It("follows a schedule file", func() {
+		f, err := ioutil.TempFile("", "schedule")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.Remove(f.Name())
+
+		_, err = f.WriteString("# warm up\n0s 1000\n1m 2000\n3m 4000 ramp\n4m 0\n")
+		Expect(err).ToNot(HaveOccurred())
+		f.Close()
+
+		Expect(rates("schedule:"+f.Name(), 0, 90*time.Second, 2*time.Minute, 3*time.Minute, 5*time.Minute)).To(Equal([]float64{1000, 2500, 3000, 4000, 0}))
+	})
+
+	It("rejects invalid specs", func() {
+		for _, spec := range []string{"", "constant", "constant:-1", "ramp:1,2", "sine:1,2,0s", "wave:1", "schedule:/does/not/exist"} {
+			_, err := loadprofile.Parse(spec)
+			Expect(err).To(HaveOccurred(), spec)
+		}
+	})
+
+	It("sets the rate from the profile until the context is done", func() {
+		r := &spyRater{}
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+
+		loadprofile.Run(ctx, loadprofile.Ramp{From: 0, To: 1000, Duration: 100 * time.Millisecond}, r, 10*time.Millisecond)
+
+		Expect(r.rates()[0]).To(Equal(0.0))
+		Expect(len(r.rates())).To(BeNumerically(">", 5))
+		Expect(r.rates()[len(r.rates())-1]).To(BeNumerically(">", 500))
+	})
+})
+
+type spyRater struct {
+	mu     sync.Mutex
+	_rates []float64
+}
+
+func (s *spyRater) SetRate(r float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s._rates = append(s._rates, r)
+}
+
+func (s *spyRater) rates() []float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s._rates
+}
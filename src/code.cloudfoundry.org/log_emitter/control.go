@@ -0,0 +1,110 @@
+package main
+
+import (
+	"os"
+	"sync"
+
+	"code.cloudfoundry.org/control"
+	"code.cloudfoundry.org/log_emitter/internal/payload"
+	"code.cloudfoundry.org/log_emitter/internal/reader"
+	"code.cloudfoundry.org/log_emitter/internal/writer"
+)
+
+// payloadSettings holds the specs of the writer's payload so that they can
+// be reported and changed one at a time through the control API.
+type payloadSettings struct {
+	w *writer.Writer
+
+	maxSize int
+
+	mu      sync.Mutex
+	size    string
+	content string
+}
+
+func (p *payloadSettings) setting(field *string) control.Setting {
+	return control.Setting{
+		Get: func() string {
+			p.mu.Lock()
+			defer p.mu.Unlock()
+
+			return *field
+		},
+		Set: func(v string) error {
+			p.mu.Lock()
+			defer p.mu.Unlock()
+
+			old := *field
+			*field = v
+			err := p.apply()
+			if err != nil {
+				*field = old
+			}
+
+			return err
+		},
+	}
+}
+
+func (p *payloadSettings) apply() error {
+	sizer, err := payload.ParseSizer(p.size, p.maxSize)
+	if err != nil {
+		return err
+	}
+
+	content, err := payload.ParseContent(p.content)
+	if err != nil {
+		return err
+	}
+
+	p.w.SetPayload(sizer, content)
+
+	return nil
+}
+
+// newController returns a controller for the writer's rate and payload
+// that reports the sent count and, on the instance that reads, the
+// received count for every egress.
+func newController(
+	w *writer.Writer,
+	readers []*reader.Reader,
+	logsPerSecond float64,
+	sizeSpec string,
+	maxSize int,
+	contentSpec string,
+	token string,
+	maxRate float64,
+) *control.Controller {
+	p := &payloadSettings{
+		w:       w,
+		maxSize: maxSize,
+		size:    sizeSpec,
+		content: contentSpec,
+	}
+
+	return control.New(w, logsPerSecond,
+		control.WithToken(token),
+		control.WithMaxRate(maxRate),
+		control.WithSetting("log_size_distribution", p.setting(&p.size)),
+		control.WithSetting("log_content", p.setting(&p.content)),
+		control.WithCounts(func() map[string]int64 {
+			counts := map[string]int64{"sent": w.Sent()}
+			for _, r := range readers {
+				counts["received_"+r.Egress()] = r.Received()
+			}
+
+			return counts
+		}),
+	)
+}
+
+// controlAddr returns addr, or the address of the port CF routes to the
+// app if addr is empty and there is a token to protect the API with.
+func controlAddr(addr, token string) string {
+	port := os.Getenv("PORT")
+	if addr != "" || token == "" || port == "" {
+		return addr
+	}
+
+	return ":" + port
+}
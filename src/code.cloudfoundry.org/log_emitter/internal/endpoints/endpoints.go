@@ -0,0 +1,184 @@
+// Package endpoints discovers the addresses of the Cloud Foundry components
+// log_emitter talks to from the CF API.
+package endpoints
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Endpoints are the addresses of the components, without trailing
+// slashes. Empty addresses are unknown.
+type Endpoints struct {
+	UAA       string
+	Login     string
+	Doppler   string
+	LogStream string
+	LogCache  string
+}
+
+// Discover reads the endpoints from the CF API's root document, falling
+// back to /v2/info for APIs that predate it. Foundations that have disabled
+// the v2 API only serve the root document. Endpoints that neither document
+// lists are derived from the API address, e.g. https://log-cache.example.com
+// for https://api.example.com.
+func Discover(client *http.Client, apiAddr string) (Endpoints, error) {
+	apiAddr = strings.TrimRight(apiAddr, "/")
+
+	e, rootErr := fromRoot(client, apiAddr)
+	if rootErr != nil {
+		var v2Err error
+		e, v2Err = fromV2Info(client, apiAddr)
+		if v2Err != nil {
+			return Endpoints{}, fmt.Errorf("failed to discover endpoints from %s: root: %s, v2 info: %s", apiAddr, rootErr, v2Err)
+		}
+	}
+
+	if e.LogStream == "" {
+		e.LogStream = derive(apiAddr, "log-stream")
+	}
+	if e.LogCache == "" {
+		e.LogCache = derive(apiAddr, "log-cache")
+	}
+
+	return e, nil
+}
+
+// Override replaces every endpoint that is set in o.
+func (e Endpoints) Override(o Endpoints) Endpoints {
+	for _, f := range []struct {
+		dst *string
+		src string
+	}{
+		{&e.UAA, o.UAA},
+		{&e.Login, o.Login},
+		{&e.Doppler, o.Doppler},
+		{&e.LogStream, o.LogStream},
+		{&e.LogCache, o.LogCache},
+	} {
+		if f.src != "" {
+			*f.dst = strings.TrimRight(f.src, "/")
+		}
+	}
+
+	return e
+}
+
+// Require returns an error naming every one of the endpoints that is
+// unknown. Names are uaa, login, logging, log_stream and log_cache, as in
+// the root document.
+func (e Endpoints) Require(names ...string) error {
+	addrs := map[string]string{
+		"uaa":        e.UAA,
+		"login":      e.Login,
+		"logging":    e.Doppler,
+		"log_stream": e.LogStream,
+		"log_cache":  e.LogCache,
+	}
+
+	var missing []string
+	for _, n := range names {
+		if addrs[n] == "" {
+			missing = append(missing, n)
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("unable to discover endpoints: %s", strings.Join(missing, ", "))
+	}
+
+	return nil
+}
+
+type link struct {
+	Href string `json:"href"`
+}
+
+func fromRoot(client *http.Client, apiAddr string) (Endpoints, error) {
+	var root struct {
+		Links map[string]*link `json:"links"`
+	}
+	err := get(client, apiAddr+"/", &root)
+	if err != nil {
+		return Endpoints{}, err
+	}
+	if len(root.Links) == 0 {
+		return Endpoints{}, errors.New("root document has no links")
+	}
+
+	href := func(name string) string {
+		if l := root.Links[name]; l != nil {
+			return strings.TrimRight(l.Href, "/")
+		}
+		return ""
+	}
+
+	return Endpoints{
+		UAA:       href("uaa"),
+		Login:     href("login"),
+		Doppler:   href("logging"),
+		LogStream: href("log_stream"),
+		LogCache:  href("log_cache"),
+	}, nil
+}
+
+func fromV2Info(client *http.Client, apiAddr string) (Endpoints, error) {
+	var info struct {
+		DopplerAddr string `json:"doppler_logging_endpoint"`
+		UAAAddr     string `json:"token_endpoint"`
+		LoginAddr   string `json:"authorization_endpoint"`
+	}
+	err := get(client, apiAddr+"/v2/info", &info)
+	if err != nil {
+		return Endpoints{}, err
+	}
+
+	return Endpoints{
+		UAA:     strings.TrimRight(info.UAAAddr, "/"),
+		Login:   strings.TrimRight(info.LoginAddr, "/"),
+		Doppler: strings.TrimRight(info.DopplerAddr, "/"),
+	}, nil
+}
+
+func get(client *http.Client, addr string, v interface{}) error {
+	resp, err := client.Get(addr)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("expected 200 status code from %s, got %d", addr, resp.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	err = json.Unmarshal(body, v)
+	if err != nil {
+		return fmt.Errorf("failed to unmarshal %s: %s", addr, err)
+	}
+
+	return nil
+}
+
+// derive returns the conventional address of a system component for an API
+// address, or "" if the API address does not start with api.
+func derive(apiAddr, host string) string {
+	u, err := url.Parse(apiAddr)
+	if err != nil || !strings.HasPrefix(u.Host, "api.") {
+		return ""
+	}
+
+	u.Host = host + "." + strings.TrimPrefix(u.Host, "api.")
+	u.Path = ""
+
+	return u.String()
+}
@@ -0,0 +1,167 @@
+package endpoints_test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+
+	"code.cloudfoundry.org/log_emitter/internal/endpoints"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Discover", func() {
+	var (
+		root   func(w http.ResponseWriter)
+		v2Info func(w http.ResponseWriter)
+		api    *httptest.Server
+		client *http.Client
+	)
+
+	BeforeEach(func() {
+		root = func(w http.ResponseWriter) { w.WriteHeader(http.StatusNotFound) }
+		v2Info = func(w http.ResponseWriter) { w.WriteHeader(http.StatusNotFound) }
+
+		api = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/":
+				root(w)
+			case "/v2/info":
+				v2Info(w)
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+
+		// Every host resolves to the fake API, so that addresses can be
+		// derived from api.example.com.
+		client = &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+					return (&net.Dialer{}).DialContext(ctx, network, api.Listener.Addr().String())
+				},
+			},
+		}
+	})
+
+	AfterEach(func() {
+		api.Close()
+	})
+
+	It("reads the endpoints from the root document", func() {
+		root = func(w http.ResponseWriter) {
+			w.Write([]byte(`{"links": {
+				"uaa": {"href": "https://uaa.example.com/"},
+				"login": {"href": "https://login.example.com"},
+				"logging": {"href": "wss://doppler.example.com:443"},
+				"log_stream": {"href": "https://log-stream.example.com"},
+				"log_cache": {"href": "https://log-cache.example.com"},
+				"cloud_controller_v3": null
+			}}`))
+		}
+
+		e, err := endpoints.Discover(client, "http://api.example.com/")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(e).To(Equal(endpoints.Endpoints{
+			UAA:       "https://uaa.example.com",
+			Login:     "https://login.example.com",
+			Doppler:   "wss://doppler.example.com:443",
+			LogStream: "https://log-stream.example.com",
+			LogCache:  "https://log-cache.example.com",
+		}))
+	})
+
+	It("falls back to /v2/info when there is no root document", func() {
+		v2Info = func(w http.ResponseWriter) {
+			w.Write([]byte(`{
+				"token_endpoint": "https://uaa.example.com",
+				"authorization_endpoint": "https://login.example.com/",
+				"doppler_logging_endpoint": "wss://doppler.example.com:443"
+			}`))
+		}
+
+		e, err := endpoints.Discover(client, "http://api.example.com")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(e).To(Equal(endpoints.Endpoints{
+			UAA:       "https://uaa.example.com",
+			Login:     "https://login.example.com",
+			Doppler:   "wss://doppler.example.com:443",
+			LogStream: "http://log-stream.example.com",
+			LogCache:  "http://log-cache.example.com",
+		}))
+	})
+
+	It("falls back to /v2/info when the root document has no links", func() {
+		root = func(w http.ResponseWriter) { w.Write([]byte(`{}`)) }
+		v2Info = func(w http.ResponseWriter) {
+			w.Write([]byte(`{"token_endpoint": "https://uaa.example.com"}`))
+		}
+
+		e, err := endpoints.Discover(client, "http://api.example.com")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(e.UAA).To(Equal("https://uaa.example.com"))
+	})
+
+	It("derives the log stream and Log Cache addresses the root document leaves out", func() {
+		root = func(w http.ResponseWriter) {
+			w.Write([]byte(`{"links": {"uaa": {"href": "https://uaa.example.com"}}}`))
+		}
+
+		e, err := endpoints.Discover(client, "http://api.example.com")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(e.LogStream).To(Equal("http://log-stream.example.com"))
+		Expect(e.LogCache).To(Equal("http://log-cache.example.com"))
+		Expect(e.Doppler).To(BeEmpty())
+	})
+
+	It("does not derive addresses for APIs that are not on an api host", func() {
+		root = func(w http.ResponseWriter) {
+			w.Write([]byte(`{"links": {"uaa": {"href": "https://uaa.example.com"}}}`))
+		}
+
+		e, err := endpoints.Discover(client, "http://cf.example.com")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(e.LogStream).To(BeEmpty())
+		Expect(e.LogCache).To(BeEmpty())
+	})
+
+	It("fails when neither document can be read", func() {
+		root = func(w http.ResponseWriter) { w.Write([]byte(`not json`)) }
+
+		_, err := endpoints.Discover(client, "http://api.example.com")
+		Expect(err).To(MatchError(And(
+			ContainSubstring("failed to discover endpoints from http://api.example.com"),
+			ContainSubstring("root: failed to unmarshal"),
+			ContainSubstring("v2 info: expected 200 status code"),
+		)))
+	})
+})
+
+var _ = Describe("Endpoints", func() {
+	It("overrides the endpoints that are set", func() {
+		e := endpoints.Endpoints{
+			UAA:     "https://uaa.example.com",
+			Doppler: "wss://doppler.example.com",
+		}
+
+		e = e.Override(endpoints.Endpoints{
+			Doppler:  "wss://other-doppler.example.com/",
+			LogCache: "https://log-cache.example.com",
+		})
+
+		Expect(e).To(Equal(endpoints.Endpoints{
+			UAA:      "https://uaa.example.com",
+			Doppler:  "wss://other-doppler.example.com",
+			LogCache: "https://log-cache.example.com",
+		}))
+	})
+
+	It("names every required endpoint that is unknown", func() {
+		e := endpoints.Endpoints{UAA: "https://uaa.example.com"}
+
+		Expect(e.Require("uaa")).To(Succeed())
+		Expect(e.Require("uaa", "logging", "log_cache")).To(MatchError("unable to discover endpoints: logging, log_cache"))
+	})
+})
@@ -0,0 +1,121 @@
+// Package latency records the distribution of message delays in a fixed
+// amount of memory, however many messages are received.
+package latency
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/datadogreporter"
+)
+
+// Buckets grow by this factor, so percentiles are accurate to within 5%.
+const (
+	growth     = 1.05
+	numBuckets = 300
+)
+
+// Recorder counts delays into exponentially sized millisecond buckets.
+type Recorder struct {
+	mu     sync.Mutex
+	counts [numBuckets]int64
+	total  int64
+	max    time.Duration
+}
+
+// Record adds a delay. Negative delays, caused by clock skew between the
+// writer and the reader, are recorded as zero.
+func (r *Recorder) Record(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.counts[bucket(d)]++
+	r.total++
+	if d > r.max {
+		r.max = d
+	}
+}
+
+// BuildPoints reports the p50, p95, p99 and max delay in milliseconds of
+// the delays recorded since the last call, and resets the recorder. No
+// points are returned if nothing was recorded.
+func (r *Recorder) BuildPoints(w datadogreporter.Window, tags []string) []datadogreporter.Point {
+	r.mu.Lock()
+	counts, total, max := r.counts, r.total, r.max
+	r.counts = [numBuckets]int64{}
+	r.total = 0
+	r.max = 0
+	r.mu.Unlock()
+
+	if total == 0 {
+		return nil
+	}
+
+	maxMS := int64(max / time.Millisecond)
+	stats := []struct {
+		name  string
+		value int64
+	}{
+		{"p50", percentile(counts, total, 50, maxMS)},
+		{"p95", percentile(counts, total, 95, maxMS)},
+		{"p99", percentile(counts, total, 99, maxMS)},
+		{"max", maxMS},
+	}
+
+	points := make([]datadogreporter.Point, 0, len(stats))
+	for _, s := range stats {
+		points = append(points, datadogreporter.Point{
+			Metric:   "capacity_planning.latency_ms." + s.name,
+			Points:   [][]int64{{w.Start.Unix(), s.value}},
+			Type:     "gauge",
+			Tags:     tags,
+			Absolute: true,
+		})
+	}
+
+	return points
+}
+
+// bucket returns the index of the bucket for d. Bucket i holds delays of
+// up to growth^i milliseconds.
+func bucket(d time.Duration) int {
+	ms := float64(d) / float64(time.Millisecond)
+	if ms <= 1 {
+		return 0
+	}
+
+	i := int(math.Ceil(math.Log(ms) / math.Log(growth)))
+	if i >= numBuckets {
+		return numBuckets - 1
+	}
+
+	return i
+}
+
+// percentile returns the upper bound, in milliseconds, of the bucket that
+// holds the nearest-rank percentile, capped at the max.
+func percentile(counts [numBuckets]int64, total, p, maxMS int64) int64 {
+	rank := (p*total + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+
+	var seen int64
+	for i, c := range counts {
+		seen += c
+		if seen >= rank {
+			v := int64(math.Round(math.Pow(growth, float64(i))))
+			if v > maxMS {
+				v = maxMS
+			}
+			return v
+		}
+	}
+
+	return 0
+}
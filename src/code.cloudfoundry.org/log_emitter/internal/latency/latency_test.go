@@ -0,0 +1,102 @@
+package latency_test
+
+import (
+	"time"
+
+	"code.cloudfoundry.org/datadogreporter"
+	"code.cloudfoundry.org/log_emitter/internal/latency"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Recorder", func() {
+	var (
+		r latency.Recorder
+		w datadogreporter.Window
+	)
+
+	BeforeEach(func() {
+		r = latency.Recorder{}
+		w = datadogreporter.Window{Start: time.Unix(1200, 0), End: time.Unix(1260, 0)}
+	})
+
+	// values returns the reported value of every statistic.
+	values := func() map[string]int64 {
+		v := make(map[string]int64)
+		for _, p := range r.BuildPoints(w, []string{"egress:v1"}) {
+			Expect(p.Tags).To(Equal([]string{"egress:v1"}))
+			Expect(p.Absolute).To(BeTrue())
+			Expect(p.Points).To(HaveLen(1))
+			Expect(p.Points[0][0]).To(Equal(int64(1200)))
+			v[p.Metric] = p.Points[0][1]
+		}
+
+		return v
+	}
+
+	It("reports percentiles and the max in milliseconds", func() {
+		for i := 1; i <= 100; i++ {
+			r.Record(time.Duration(i) * time.Millisecond)
+		}
+
+		v := values()
+		Expect(v).To(HaveLen(4))
+		Expect(v["capacity_planning.latency_ms.p50"]).To(BeNumerically("~", 50, 50*0.05))
+		Expect(v["capacity_planning.latency_ms.p95"]).To(BeNumerically("~", 95, 95*0.05))
+		Expect(v["capacity_planning.latency_ms.p99"]).To(BeNumerically("~", 99, 99*0.05))
+		Expect(v["capacity_planning.latency_ms.max"]).To(Equal(int64(100)))
+	})
+
+	It("caps percentiles at the max", func() {
+		r.Record(1234 * time.Millisecond)
+
+		v := values()
+		Expect(v["capacity_planning.latency_ms.p50"]).To(Equal(int64(1234)))
+		Expect(v["capacity_planning.latency_ms.p99"]).To(Equal(int64(1234)))
+		Expect(v["capacity_planning.latency_ms.max"]).To(Equal(int64(1234)))
+	})
+
+	It("records negative delays from clock skew as zero", func() {
+		r.Record(-5 * time.Second)
+		r.Record(-time.Millisecond)
+
+		Expect(values()).To(Equal(map[string]int64{
+			"capacity_planning.latency_ms.p50": 0,
+			"capacity_planning.latency_ms.p95": 0,
+			"capacity_planning.latency_ms.p99": 0,
+			"capacity_planning.latency_ms.max": 0,
+		}))
+	})
+
+	It("does not let skewed delays hide the others", func() {
+		for i := 0; i < 99; i++ {
+			r.Record(-time.Second)
+		}
+		r.Record(500 * time.Millisecond)
+
+		v := values()
+		// Delays of up to a millisecond share the first bucket.
+		Expect(v["capacity_planning.latency_ms.p50"]).To(Equal(int64(1)))
+		Expect(v["capacity_planning.latency_ms.max"]).To(Equal(int64(500)))
+	})
+
+	It("reports nothing for an empty window", func() {
+		Expect(r.BuildPoints(w, nil)).To(BeNil())
+	})
+
+	It("resets after every report", func() {
+		r.Record(10 * time.Millisecond)
+		Expect(values()).To(HaveLen(4))
+
+		Expect(r.BuildPoints(w, nil)).To(BeNil())
+	})
+
+	It("holds delays beyond the largest bucket", func() {
+		r.Record(time.Hour)
+
+		v := values()
+		Expect(v["capacity_planning.latency_ms.max"]).To(Equal(int64(time.Hour / time.Millisecond)))
+		Expect(v["capacity_planning.latency_ms.p50"]).To(BeNumerically(">", 0))
+	})
+})
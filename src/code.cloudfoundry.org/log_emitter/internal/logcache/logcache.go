@@ -0,0 +1,319 @@
+// Package logcache counts how many of log_emitter's messages were stored
+// by Log Cache, by paging through the envelopes it retains for the app.
+package logcache
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/authenticator"
+	"code.cloudfoundry.org/datadogreporter"
+	"code.cloudfoundry.org/log_emitter/internal/message"
+	"code.cloudfoundry.org/log_emitter/internal/reader"
+	"code.cloudfoundry.org/tlsclient"
+)
+
+const (
+	defaultInterval = 30 * time.Second
+	defaultLag      = 10 * time.Second
+	pageSize        = 1000
+)
+
+// Verifier reads the app's log envelopes from Log Cache. Every read starts
+// where the last one ended, so each envelope is counted once. Reads stop
+// short of the current time by a lag, so that envelopes that are still
+// being written are not skipped.
+//
+// Pages are requested from the timestamp of the last envelope counted,
+// skipping the envelopes at that timestamp that have already been counted,
+// so that envelopes sharing a timestamp across a page boundary are not
+// lost. Log Cache cannot page within a timestamp, so if more than a page
+// of envelopes share one, only the first page of them is counted.
+//
+// Log Cache only retains a limited number of envelopes per app, so the
+// interval must be short enough that envelopes are read before they are
+// evicted.
+type Verifier struct {
+	addr     string
+	sourceID string
+	auth     *authenticator.Authenticator
+	client   *http.Client
+	interval time.Duration
+	lag      time.Duration
+
+	start        time.Time
+	skip         int
+	readFailures datadogreporter.Counter
+
+	mu      sync.Mutex
+	sources map[string]*source
+}
+
+type source struct {
+	cell   string
+	cached datadogreporter.Counter
+}
+
+// New returns a verifier for messages written from now on.
+func New(
+	addr string,
+	sourceID string,
+	a *authenticator.Authenticator,
+	tlsConfig *tls.Config,
+	opts ...verifierOpt,
+) *Verifier {
+	v := &Verifier{
+		addr:     addr,
+		sourceID: sourceID,
+		auth:     a,
+		client:   tlsclient.HTTPClient(tlsConfig),
+		interval: defaultInterval,
+		lag:      defaultLag,
+		start:    time.Now(),
+		sources:  make(map[string]*source),
+	}
+
+	for _, o := range opts {
+		o(v)
+	}
+
+	return v
+}
+
+type verifierOpt func(*Verifier)
+
+// WithInterval sets how often Log Cache is read.
+func WithInterval(d time.Duration) verifierOpt {
+	return func(v *Verifier) {
+		if d > 0 {
+			v.interval = d
+		}
+	}
+}
+
+// WithLag sets how far behind the current time reads stop.
+func WithLag(d time.Duration) verifierOpt {
+	return func(v *Verifier) {
+		if d >= 0 {
+			v.lag = d
+		}
+	}
+}
+
+// Run reads Log Cache every interval until the context is done.
+func (v *Verifier) Run(ctx context.Context) {
+	t := time.NewTicker(v.interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+
+		err := v.read(ctx, time.Now().Add(-v.lag))
+		if err != nil && ctx.Err() == nil {
+			v.readFailures.Add(1)
+			log.Printf("failed to read from log cache: %s", err)
+		}
+	}
+}
+
+// BuildPoints returns the number of messages found in Log Cache from every
+// instance of the app, and the number of reads that failed.
+func (v *Verifier) BuildPoints(w datadogreporter.Window, tags []string) []datadogreporter.Point {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	names := make([]string, 0, len(v.sources))
+	for name := range v.sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var points []datadogreporter.Point
+	for _, name := range names {
+		s := v.sources[name]
+		points = append(points, datadogreporter.Point{
+			Metric: "capacity_planning.cached",
+			Points: s.cached.Samples(w),
+			Type:   "gauge",
+			Tags:   append(reader.SourceTags(name, s.cell), tags...),
+		})
+	}
+
+	return append(points, datadogreporter.Point{
+		Metric: "capacity_planning.log_cache.read_failures",
+		Points: v.readFailures.Samples(w),
+		Type:   "gauge",
+		Tags:   tags,
+	})
+}
+
+type readResponse struct {
+	Envelopes struct {
+		Batch []envelope `json:"batch"`
+	} `json:"envelopes"`
+}
+
+type envelope struct {
+	Timestamp  string `json:"timestamp"`
+	InstanceID string `json:"instance_id"`
+	Log        *struct {
+		Payload string `json:"payload"`
+	} `json:"log"`
+}
+
+// read pages through the envelopes from the end of the last read up to
+// end. On failure the next read retries from the last page that was
+// counted.
+func (v *Verifier) read(ctx context.Context, end time.Time) error {
+	for v.start.Before(end) {
+		resp, err := v.page(ctx, v.start, end)
+		if err != nil {
+			return err
+		}
+
+		batch := resp.Envelopes.Batch
+		timestamps := make([]int64, len(batch))
+		for i, e := range batch {
+			timestamps[i], err = strconv.ParseInt(e.Timestamp, 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid envelope timestamp %q", e.Timestamp)
+			}
+		}
+
+		// The page starts with the envelopes at the start time that were
+		// counted by the last page.
+		start := v.start.UnixNano()
+		skip := v.skip
+		for i, e := range batch {
+			if skip > 0 && timestamps[i] == start {
+				skip--
+				continue
+			}
+			v.observeEnvelope(e)
+		}
+
+		if len(batch) < pageSize {
+			v.start = end
+			v.skip = 0
+			return nil
+		}
+
+		last := timestamps[len(timestamps)-1]
+		var atLast int
+		for _, ts := range timestamps {
+			if ts == last {
+				atLast++
+			}
+		}
+
+		if last == start && atLast <= v.skip {
+			log.Printf("more than %d log cache envelopes at %d, skipping the rest of them", pageSize, last)
+			v.start = time.Unix(0, last+1)
+			v.skip = 0
+			continue
+		}
+
+		v.start = time.Unix(0, last)
+		v.skip = atLast
+	}
+
+	return nil
+}
+
+func (v *Verifier) observeEnvelope(e envelope) {
+	if e.Log == nil {
+		return
+	}
+
+	body, err := base64.StdEncoding.DecodeString(e.Log.Payload)
+	if err != nil {
+		return
+	}
+	v.observe(body, e.InstanceID)
+}
+
+func (v *Verifier) page(ctx context.Context, start, end time.Time) (*readResponse, error) {
+	q := url.Values{}
+	q.Set("start_time", strconv.FormatInt(start.UnixNano(), 10))
+	q.Set("end_time", strconv.FormatInt(end.UnixNano(), 10))
+	q.Set("envelope_types", "LOG")
+	q.Set("limit", strconv.Itoa(pageSize))
+
+	req, err := http.NewRequest(http.MethodGet, v.addr+"/api/v1/read/"+url.PathEscape(v.sourceID)+"?"+q.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+
+	token, err := v.auth.Token()
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", token)
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		if resp.StatusCode == http.StatusUnauthorized {
+			v.auth.RefreshAuthToken()
+		}
+		return nil, fmt.Errorf("log cache returned %d: %s", resp.StatusCode, body)
+	}
+
+	var r readResponse
+	err = json.Unmarshal(body, &r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal log cache response: %s", err)
+	}
+
+	return &r, nil
+}
+
+// observe counts a message written by log_emitter against the instance
+// that sent it.
+func (v *Verifier) observe(body []byte, instance string) {
+	h, ok := message.Parse(body)
+	if !ok {
+		return
+	}
+	if instance == "" {
+		instance = h.Instance
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	s, ok := v.sources[instance]
+	if !ok {
+		s = &source{}
+		v.sources[instance] = s
+	}
+	if h.Cell != "" {
+		s.cell = h.Cell
+	}
+	s.cached.Add(1)
+}
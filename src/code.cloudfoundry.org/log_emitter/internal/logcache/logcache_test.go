@@ -0,0 +1,257 @@
+package logcache_test
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/authenticator"
+	"code.cloudfoundry.org/datadogreporter"
+	"code.cloudfoundry.org/log_emitter/internal/logcache"
+	"code.cloudfoundry.org/log_emitter/internal/message"
+	"code.cloudfoundry.org/log_emitter/internal/payload"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Verifier", func() {
+	var (
+		cache    *fakeLogCache
+		uaa      *httptest.Server
+		uaaCalls int
+		uaaMu    sync.Mutex
+		verifier *logcache.Verifier
+		cancel   context.CancelFunc
+		totals   map[string]int64
+	)
+
+	BeforeEach(func() {
+		cache = &fakeLogCache{}
+		cache.server = httptest.NewServer(cache)
+
+		uaaCalls = 0
+		uaa = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			uaaMu.Lock()
+			uaaCalls++
+			uaaMu.Unlock()
+
+			fmt.Fprint(w, `{"access_token":"token","expires_in":3600}`)
+		}))
+
+		auth := authenticator.New("client", "secret", uaa.URL, authenticator.WithMinRefreshInterval(0))
+		verifier = logcache.New(
+			cache.server.URL,
+			"app-id",
+			auth,
+			nil,
+			logcache.WithInterval(10*time.Millisecond),
+			logcache.WithLag(0),
+		)
+		totals = make(map[string]int64)
+	})
+
+	AfterEach(func() {
+		cancel()
+		cache.server.Close()
+		uaa.Close()
+	})
+
+	run := func() {
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(context.Background())
+		go verifier.Run(ctx)
+	}
+
+	// counts returns the totals of every point reported so far, keyed by
+	// metric and tags.
+	counts := func() map[string]int64 {
+		end := time.Now().Truncate(time.Second).Add(time.Second)
+		for _, p := range verifier.BuildPoints(datadogreporter.Window{Start: end.Add(-time.Minute), End: end}, []string{"app"}) {
+			key := fmt.Sprint(p.Metric, p.Tags)
+			totals[key] += 0
+			for _, v := range p.Points {
+				totals[key] += v[1]
+			}
+		}
+
+		return totals
+	}
+
+	cached := func(instance string) func() int64 {
+		return func() int64 {
+			return counts()[fmt.Sprintf("capacity_planning.cached[source_instance:%s app]", instance)]
+		}
+	}
+
+	readFailures := func() int64 {
+		return counts()["capacity_planning.log_cache.read_failures[app]"]
+	}
+
+	It("counts every envelope once across pages", func() {
+		ts := time.Now().UnixNano()
+		for i := int64(0); i < 2500; i++ {
+			cache.add(ts+i, "0")
+		}
+		run()
+
+		Eventually(cached("0"), "2s").Should(Equal(int64(2500)))
+		Consistently(cached("0"), "100ms").Should(Equal(int64(2500)))
+		Expect(cache.requests()).To(BeNumerically(">=", 3))
+	})
+
+	It("counts envelopes that share a timestamp across a page boundary", func() {
+		ts := time.Now().UnixNano()
+		for i := int64(0); i < 900; i++ {
+			cache.add(ts+i, "0")
+		}
+		for i := 0; i < 600; i++ {
+			cache.add(ts+1000, "1")
+		}
+		cache.add(ts+1001, "1")
+		run()
+
+		Eventually(cached("1"), "2s").Should(Equal(int64(601)))
+		Consistently(cached("1"), "100ms").Should(Equal(int64(601)))
+		Eventually(cached("0"), "2s").Should(Equal(int64(900)))
+	})
+
+	It("moves on when more than a page of envelopes share a timestamp", func() {
+		ts := time.Now().UnixNano()
+		for i := 0; i < 1500; i++ {
+			cache.add(ts, "0")
+		}
+		cache.add(ts+1, "1")
+		run()
+
+		Eventually(cached("1"), "2s").Should(Equal(int64(1)))
+		Eventually(cached("0"), "2s").Should(Equal(int64(1000)))
+		Consistently(cached("0"), "100ms").Should(Equal(int64(1000)))
+	})
+
+	It("attributes envelopes to the instance in the message when the envelope has none", func() {
+		cache.add(time.Now().UnixNano(), "")
+		run()
+
+		Eventually(cached("7"), "2s").Should(Equal(int64(1)))
+	})
+
+	It("counts failed reads and retries from the last page counted", func() {
+		ts := time.Now().UnixNano()
+		for i := int64(0); i < 1500; i++ {
+			cache.add(ts+i, "0")
+		}
+		cache.failAfter(1, http.StatusInternalServerError, 3)
+		run()
+
+		Eventually(readFailures, "2s").Should(BeNumerically(">=", 3))
+		Eventually(cached("0"), "2s").Should(Equal(int64(1500)))
+		Consistently(cached("0"), "100ms").Should(Equal(int64(1500)))
+	})
+
+	It("refreshes the token when Log Cache rejects it", func() {
+		cache.add(time.Now().UnixNano(), "0")
+		cache.failAfter(0, http.StatusUnauthorized, 1)
+		run()
+
+		Eventually(cached("0"), "2s").Should(Equal(int64(1)))
+		uaaMu.Lock()
+		defer uaaMu.Unlock()
+		Expect(uaaCalls).To(Equal(2))
+		Expect(cache.authorization()).To(Equal("bearer token"))
+	})
+})
+
+// fakeLogCache serves envelopes from /api/v1/read like Log Cache: those
+// from start_time up to but not including end_time, oldest first, up to the
+// limit.
+type fakeLogCache struct {
+	server *httptest.Server
+
+	mu        sync.Mutex
+	envelopes []map[string]interface{}
+	reqs      int
+	failFrom  int
+	failCount int
+	failCode  int
+	auth      string
+}
+
+func (f *fakeLogCache) add(ts int64, sourceInstance string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	instance := sourceInstance
+	if instance == "" {
+		instance = "7"
+	}
+	msg := message.NewEncoder("run", instance, "", payload.Fixed(0), nil).Next()
+
+	f.envelopes = append(f.envelopes, map[string]interface{}{
+		"timestamp":   strconv.FormatInt(ts, 10),
+		"instance_id": sourceInstance,
+		"log":         map[string]string{"payload": base64.StdEncoding.EncodeToString(msg)},
+	})
+}
+
+// failAfter fails count requests with code after the first n succeed.
+func (f *fakeLogCache) failAfter(n, code, count int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.failFrom = n
+	f.failCode = code
+	f.failCount = count
+}
+
+func (f *fakeLogCache) requests() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.reqs
+}
+
+func (f *fakeLogCache) authorization() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.auth
+}
+
+func (f *fakeLogCache) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.reqs++
+	f.auth = r.Header.Get("Authorization")
+	Expect(r.URL.Path).To(Equal("/api/v1/read/app-id"))
+
+	if f.reqs > f.failFrom && f.failCount > 0 {
+		f.failCount--
+		w.WriteHeader(f.failCode)
+		return
+	}
+
+	q := r.URL.Query()
+	start, _ := strconv.ParseInt(q.Get("start_time"), 10, 64)
+	end, _ := strconv.ParseInt(q.Get("end_time"), 10, 64)
+	limit, _ := strconv.Atoi(q.Get("limit"))
+
+	batch := []map[string]interface{}{}
+	for _, e := range f.envelopes {
+		ts, _ := strconv.ParseInt(e["timestamp"].(string), 10, 64)
+		if ts >= start && ts < end && len(batch) < limit {
+			batch = append(batch, e)
+		}
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"envelopes": map[string]interface{}{"batch": batch},
+	})
+}
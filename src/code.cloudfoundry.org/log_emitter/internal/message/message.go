@@ -0,0 +1,141 @@
+// Package message encodes the log messages emitted by log_emitter so that
+// the reader can tell which instance sent each message and in what order.
+//
+// A message looks like
+//
+//	capacity-planning run=<run id> instance=<index> [cell=<cell>] seq=<n> ts=<unix nanos> ????...
+//
+// padded with content to the size chosen for it. The run ID changes every time the
+// instance starts, cell identifies the Diego cell the instance runs on, seq
+// counts up from one within a run and ts is the time the message was
+// written.
+package message
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	mathrand "math/rand"
+	"strconv"
+	"time"
+
+	"code.cloudfoundry.org/log_emitter/internal/payload"
+)
+
+const prefix = "capacity-planning "
+
+// Header identifies a single message.
+type Header struct {
+	RunID    string
+	Instance string
+	Cell     string
+	Seq      uint64
+	SentAt   time.Time
+}
+
+// Encoder builds consecutive messages for one run of an instance.
+type Encoder struct {
+	head    []byte
+	sizer   payload.Sizer
+	content payload.Content
+	rand    *mathrand.Rand
+	seq     uint64
+	buf     []byte
+}
+
+// NewEncoder returns an encoder for a run of an instance. The cell is left
+// out of messages when it is empty.
+func NewEncoder(runID, instance, cell string, sizer payload.Sizer, content payload.Content) *Encoder {
+	head := fmt.Sprintf("%srun=%s instance=%s ", prefix, runID, instance)
+	if cell != "" {
+		head += "cell=" + cell + " "
+	}
+
+	return &Encoder{
+		head:    []byte(head + "seq="),
+		sizer:   sizer,
+		content: content,
+		rand:    mathrand.New(mathrand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// SetPayload changes the sizes and content of the following messages.
+func (e *Encoder) SetPayload(sizer payload.Sizer, content payload.Content) {
+	e.sizer = sizer
+	e.content = content
+}
+
+// Next returns the next message in the sequence. Messages are never
+// shorter than their header. The returned slice is only valid until the
+// following call.
+func (e *Encoder) Next() []byte {
+	e.seq++
+
+	e.buf = append(e.buf[:0], e.head...)
+	e.buf = strconv.AppendUint(e.buf, e.seq, 10)
+	e.buf = append(e.buf, " ts="...)
+	e.buf = strconv.AppendInt(e.buf, time.Now().UnixNano(), 10)
+	e.buf = append(e.buf, ' ')
+	if n := e.sizer.Size(e.rand) - len(e.buf); n > 0 {
+		e.buf = e.content.Append(e.buf, n, e.rand)
+	}
+
+	return e.buf
+}
+
+// Parse returns the header of a message built by an Encoder. It returns
+// false for any other message.
+func Parse(msg []byte) (Header, bool) {
+	if !bytes.HasPrefix(msg, []byte(prefix)) {
+		return Header{}, false
+	}
+
+	var h Header
+	for _, f := range bytes.Fields(msg[len(prefix):]) {
+		kv := bytes.SplitN(f, []byte("="), 2)
+		if len(kv) != 2 {
+			break
+		}
+
+		switch string(kv[0]) {
+		case "run":
+			h.RunID = string(kv[1])
+		case "instance":
+			h.Instance = string(kv[1])
+		case "cell":
+			h.Cell = string(kv[1])
+		case "seq":
+			seq, err := strconv.ParseUint(string(kv[1]), 10, 64)
+			if err != nil {
+				return Header{}, false
+			}
+			h.Seq = seq
+		case "ts":
+			ts, err := strconv.ParseInt(string(kv[1]), 10, 64)
+			if err != nil {
+				return Header{}, false
+			}
+			h.SentAt = time.Unix(0, ts)
+		default:
+			return Header{}, false
+		}
+	}
+
+	if h.RunID == "" || h.Seq == 0 {
+		return Header{}, false
+	}
+
+	return h, true
+}
+
+// NewRunID returns a random ID for a run.
+func NewRunID() string {
+	b := make([]byte, 8)
+	_, err := rand.Read(b)
+	if err != nil {
+		panic(err)
+	}
+
+	return hex.EncodeToString(b)
+}
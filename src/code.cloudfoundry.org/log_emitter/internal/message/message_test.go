@@ -0,0 +1,60 @@
+package message_test
+
+import (
+	"time"
+
+	"code.cloudfoundry.org/log_emitter/internal/message"
+	"code.cloudfoundry.org/log_emitter/internal/payload"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Encoder", func() {
+	var fill payload.Content
+
+	BeforeEach(func() {
+		var err error
+		fill, err = payload.ParseContent("fill")
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("builds messages that parse back to their headers", func() {
+		e := message.NewEncoder("run", "3", "10.0.0.1", payload.Fixed(200), fill)
+
+		before := time.Now()
+		first := string(e.Next())
+		second := e.Next()
+
+		Expect(first).To(HavePrefix("capacity-planning run=run instance=3 cell=10.0.0.1 seq=1 ts="))
+		Expect(second).To(HaveLen(200))
+
+		h, ok := message.Parse(second)
+		Expect(ok).To(BeTrue())
+		Expect(h.RunID).To(Equal("run"))
+		Expect(h.Instance).To(Equal("3"))
+		Expect(h.Cell).To(Equal("10.0.0.1"))
+		Expect(h.Seq).To(Equal(uint64(2)))
+		Expect(h.SentAt).To(BeTemporally(">=", before))
+	})
+
+	It("leaves the cell out when it is empty", func() {
+		e := message.NewEncoder("run", "0", "", payload.Fixed(0), fill)
+
+		h, ok := message.Parse(e.Next())
+		Expect(ok).To(BeTrue())
+		Expect(h.Cell).To(BeEmpty())
+	})
+
+	It("does not parse other messages", func() {
+		for _, msg := range []string{
+			"hello",
+			"capacity-planning instance=0 seq=1",
+			"capacity-planning run=run instance=0 seq=x",
+			"capacity-planning run=run instance=0 seq=1 colour=red",
+		} {
+			_, ok := message.Parse([]byte(msg))
+			Expect(ok).To(BeFalse(), msg)
+		}
+	})
+})
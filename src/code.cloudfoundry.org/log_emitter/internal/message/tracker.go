@@ -0,0 +1,211 @@
+package message
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/datadogreporter"
+)
+
+const (
+	defaultReorderWindow = 10 * time.Second
+	maxMissing           = 100000
+
+	// lateWindows is how many reorder windows a lost message is remembered
+	// for, so that it is not counted as duplicated if it arrives late.
+	lateWindows = 5
+)
+
+// Tracker follows the sequence of messages from every instance. A message
+// that is skipped is held as missing for the reorder window: if it arrives
+// in that time it is counted as reordered, otherwise as lost. A lost message
+// that arrives later is only counted as lost. Messages that arrive more than
+// once are counted as duplicated.
+type Tracker struct {
+	reorderWindow time.Duration
+
+	mu        sync.Mutex
+	instances map[string]*instance
+	expiredAt time.Time
+}
+
+type instance struct {
+	runID   string
+	retired map[string]bool
+	max     uint64
+	missing map[uint64]time.Time
+	late    map[uint64]time.Time
+
+	lost       datadogreporter.Counter
+	duplicated datadogreporter.Counter
+	reordered  datadogreporter.Counter
+}
+
+func NewTracker(opts ...trackerOpt) *Tracker {
+	t := &Tracker{
+		reorderWindow: defaultReorderWindow,
+		instances:     make(map[string]*instance),
+	}
+
+	for _, o := range opts {
+		o(t)
+	}
+
+	return t
+}
+
+type trackerOpt func(*Tracker)
+
+// WithReorderWindow sets how long a skipped message is held as missing
+// before it is counted as lost.
+func WithReorderWindow(d time.Duration) trackerOpt {
+	return func(t *Tracker) {
+		t.reorderWindow = d
+	}
+}
+
+// Observe records the arrival of a message. Missing messages are expired
+// as messages arrive, at most ten times per reorder window, so that they do
+// not build up between reports.
+func (t *Tracker) Observe(h Header) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(t.expiredAt) >= t.reorderWindow/10 {
+		t.expire(now)
+	}
+
+	inst, ok := t.instances[h.Instance]
+	if !ok {
+		inst = &instance{retired: make(map[string]bool)}
+		t.instances[h.Instance] = inst
+	}
+
+	if inst.retired[h.RunID] {
+		return
+	}
+
+	// The instance restarted. Whatever is still missing from the old run
+	// will not be sent again. Gaps before the first message seen from a
+	// run are not counted, since the reader may have started late.
+	if h.RunID != inst.runID {
+		if inst.runID != "" {
+			inst.retired[inst.runID] = true
+			inst.lost.Add(int64(len(inst.missing)))
+		}
+		inst.runID = h.RunID
+		inst.max = h.Seq
+		inst.missing = make(map[uint64]time.Time)
+		inst.late = make(map[uint64]time.Time)
+		return
+	}
+
+	switch {
+	case h.Seq == inst.max+1:
+		inst.max = h.Seq
+	case h.Seq > inst.max:
+		for s := inst.max + 1; s < h.Seq; s++ {
+			if len(inst.missing) >= maxMissing {
+				inst.lost.Add(int64(h.Seq - s))
+				break
+			}
+			inst.missing[s] = now
+		}
+		inst.max = h.Seq
+	default:
+		if _, ok := inst.missing[h.Seq]; ok {
+			delete(inst.missing, h.Seq)
+			inst.reordered.Add(1)
+			return
+		}
+		if _, ok := inst.late[h.Seq]; ok {
+			delete(inst.late, h.Seq)
+			return
+		}
+		inst.duplicated.Add(1)
+	}
+}
+
+// Missing returns the number of messages that are held as missing.
+func (t *Tracker) Missing() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var n int
+	for _, inst := range t.instances {
+		n += len(inst.missing)
+	}
+
+	return n
+}
+
+// BuildPoints returns lost, duplicated and reordered counts for every
+// instance, tagged with source_instance and the given tags.
+func (t *Tracker) BuildPoints(w datadogreporter.Window, tags []string) []datadogreporter.Point {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.expire(time.Now())
+
+	names := make([]string, 0, len(t.instances))
+	for name := range t.instances {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var points []datadogreporter.Point
+	for _, name := range names {
+		inst := t.instances[name]
+		instTags := append([]string{"source_instance:" + name}, tags...)
+
+		for _, c := range []struct {
+			metric  string
+			counter *datadogreporter.Counter
+		}{
+			{"capacity_planning.lost", &inst.lost},
+			{"capacity_planning.duplicated", &inst.duplicated},
+			{"capacity_planning.reordered", &inst.reordered},
+		} {
+			points = append(points, datadogreporter.Point{
+				Metric: c.metric,
+				Points: c.counter.Samples(w),
+				Type:   "gauge",
+				Tags:   instTags,
+			})
+		}
+	}
+
+	return points
+}
+
+// expire counts messages that have been missing for longer than the
+// reorder window as lost, and forgets lost messages that have been missing
+// for longer than lateWindows reorder windows. It must be called with mu
+// held.
+func (t *Tracker) expire(now time.Time) {
+	t.expiredAt = now
+	cutoff := now.Add(-t.reorderWindow)
+	lateCutoff := now.Add(-lateWindows * t.reorderWindow)
+
+	for _, inst := range t.instances {
+		for seq, at := range inst.late {
+			if at.Before(lateCutoff) {
+				delete(inst.late, seq)
+			}
+		}
+
+		var lost int64
+		for seq, at := range inst.missing {
+			if at.Before(cutoff) {
+				delete(inst.missing, seq)
+				if len(inst.late) < maxMissing {
+					inst.late[seq] = at
+				}
+				lost++
+			}
+		}
+		inst.lost.Add(lost)
+	}
+}
@@ -0,0 +1,115 @@
+package message_test
+
+import (
+	"time"
+
+	"code.cloudfoundry.org/datadogreporter"
+	"code.cloudfoundry.org/log_emitter/internal/message"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Tracker", func() {
+	const window = 100 * time.Millisecond
+
+	var t *message.Tracker
+
+	BeforeEach(func() {
+		t = message.NewTracker(message.WithReorderWindow(window))
+	})
+
+	observe := func(runID string, seqs ...uint64) {
+		for _, s := range seqs {
+			t.Observe(message.Header{RunID: runID, Instance: "0", Seq: s})
+		}
+	}
+
+	// counts returns the total of each metric reported for instance 0.
+	counts := func() map[string]int64 {
+		end := time.Now().Truncate(time.Second).Add(time.Second)
+		totals := make(map[string]int64)
+		for _, p := range t.BuildPoints(datadogreporter.Window{Start: end.Add(-time.Minute), End: end}, nil) {
+			Expect(p.Tags).To(Equal([]string{"source_instance:0"}))
+			totals[p.Metric] += 0
+			for _, v := range p.Points {
+				totals[p.Metric] += v[1]
+			}
+		}
+
+		return totals
+	}
+
+	It("counts nothing for messages in order", func() {
+		observe("run", 1, 2, 3, 4)
+
+		Expect(counts()).To(Equal(map[string]int64{
+			"capacity_planning.lost":       0,
+			"capacity_planning.duplicated": 0,
+			"capacity_planning.reordered":  0,
+		}))
+	})
+
+	It("counts messages that arrive within the reorder window as reordered", func() {
+		observe("run", 1, 3, 4, 2)
+
+		Expect(t.Missing()).To(Equal(0))
+		Expect(counts()).To(Equal(map[string]int64{
+			"capacity_planning.lost":       0,
+			"capacity_planning.duplicated": 0,
+			"capacity_planning.reordered":  1,
+		}))
+	})
+
+	It("counts messages that are missing for longer than the reorder window as lost", func() {
+		observe("run", 1, 5)
+		Expect(t.Missing()).To(Equal(3))
+
+		time.Sleep(2 * window)
+		Expect(counts()["capacity_planning.lost"]).To(Equal(int64(3)))
+	})
+
+	It("expires missing messages as messages arrive", func() {
+		observe("run", 1, 5)
+		time.Sleep(2 * window)
+		observe("run", 6)
+
+		Expect(t.Missing()).To(Equal(0))
+		Expect(counts()["capacity_planning.lost"]).To(Equal(int64(3)))
+	})
+
+	It("counts messages that arrive more than once as duplicated", func() {
+		observe("run", 1, 2, 2, 1)
+
+		Expect(counts()).To(Equal(map[string]int64{
+			"capacity_planning.lost":       0,
+			"capacity_planning.duplicated": 2,
+			"capacity_planning.reordered":  0,
+		}))
+	})
+
+	It("counts lost messages that arrive late only as lost", func() {
+		observe("run", 1, 3)
+		time.Sleep(2 * window)
+		observe("run", 2, 2)
+
+		Expect(counts()).To(Equal(map[string]int64{
+			"capacity_planning.lost":       1,
+			"capacity_planning.duplicated": 1,
+			"capacity_planning.reordered":  0,
+		}))
+	})
+
+	It("counts messages missing from a run as lost when the instance restarts", func() {
+		observe("old", 1, 4)
+		observe("new", 10, 11)
+		observe("old", 2)
+
+		Expect(t.Missing()).To(Equal(0))
+		Expect(counts()).To(Equal(map[string]int64{
+			"capacity_planning.lost":       2,
+			"capacity_planning.duplicated": 0,
+			"capacity_planning.reordered":  0,
+		}))
+	})
+})
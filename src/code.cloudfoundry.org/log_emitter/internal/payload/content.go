@@ -0,0 +1,90 @@
+package payload
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+)
+
+const poolSize = 1 << 20
+
+// Content fills the body of a message after its header.
+type Content interface {
+	// Append appends n bytes of content to buf.
+	Append(buf []byte, n int, r *rand.Rand) []byte
+}
+
+// ParseContent returns the named content:
+//
+//	fill    a run of '?', the most compressible
+//	text    words separated by spaces, compressible like typical logs
+//	random  random letters and digits, effectively incompressible
+func ParseContent(name string) (Content, error) {
+	switch name {
+	case "", "fill":
+		return fill{}, nil
+	case "text":
+		return pool(textPool()), nil
+	case "random":
+		return pool(randomPool()), nil
+	default:
+		return nil, fmt.Errorf("unknown log content %q, must be fill, text or random", name)
+	}
+}
+
+type fill struct{}
+
+func (fill) Append(buf []byte, n int, _ *rand.Rand) []byte {
+	for i := 0; i < n; i++ {
+		buf = append(buf, '?')
+	}
+
+	return buf
+}
+
+// pool appends slices of a pregenerated buffer, starting at random
+// offsets, so that content is cheap to produce at high rates.
+type pool []byte
+
+func (p pool) Append(buf []byte, n int, r *rand.Rand) []byte {
+	for n > 0 {
+		start := r.Intn(len(p))
+		chunk := len(p) - start
+		if chunk > n {
+			chunk = n
+		}
+		buf = append(buf, p[start:start+chunk]...)
+		n -= chunk
+	}
+
+	return buf
+}
+
+func randomPool() []byte {
+	const chars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+	r := rand.New(rand.NewSource(1))
+	b := make([]byte, poolSize)
+	for i := range b {
+		b[i] = chars[r.Intn(len(chars))]
+	}
+
+	return b
+}
+
+func textPool() []byte {
+	words := strings.Fields(`
+		request completed status ok duration ms user session cache miss hit
+		the of and to in for with on at from by error warning info debug
+		connection opened closed retry timeout upstream downstream handler
+		processed records batch queue worker started stopped health check`)
+
+	r := rand.New(rand.NewSource(1))
+	b := make([]byte, 0, poolSize+16)
+	for len(b) < poolSize {
+		b = append(b, words[r.Intn(len(words))]...)
+		b = append(b, ' ')
+	}
+
+	return b[:poolSize]
+}
@@ -0,0 +1,166 @@
+// Package payload describes the sizes and contents of emitted log messages.
+package payload
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Sizer picks the size of each message in bytes.
+type Sizer interface {
+	Size(r *rand.Rand) int
+}
+
+// ParseSizer builds a Sizer from a spec, one of:
+//
+//	fixed:<bytes>
+//	uniform:<min>-<max>
+//	lognormal:<mu>,<sigma>      (parameters of the natural log of the size)
+//	histogram:<path>            (lines of "<bytes> <weight>")
+//
+// Log-normal sizes have no upper bound, so they are capped at maxSize.
+func ParseSizer(spec string, maxSize int) (Sizer, error) {
+	parts := strings.SplitN(spec, ":", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid size distribution %q", spec)
+	}
+	kind, args := parts[0], parts[1]
+
+	switch kind {
+	case "fixed":
+		n, err := strconv.Atoi(args)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid fixed size %q", args)
+		}
+		return Fixed(n), nil
+	case "uniform":
+		bounds := strings.SplitN(args, "-", 2)
+		if len(bounds) != 2 {
+			return nil, fmt.Errorf("invalid uniform range %q, expected <min>-<max>", args)
+		}
+		min, err1 := strconv.Atoi(bounds[0])
+		max, err2 := strconv.Atoi(bounds[1])
+		if err1 != nil || err2 != nil || min < 0 || max < min {
+			return nil, fmt.Errorf("invalid uniform range %q", args)
+		}
+		return Uniform{Min: min, Max: max}, nil
+	case "lognormal":
+		params := strings.SplitN(args, ",", 2)
+		if len(params) != 2 {
+			return nil, fmt.Errorf("invalid lognormal parameters %q, expected <mu>,<sigma>", args)
+		}
+		mu, err1 := strconv.ParseFloat(params[0], 64)
+		sigma, err2 := strconv.ParseFloat(params[1], 64)
+		if err1 != nil || err2 != nil || sigma < 0 {
+			return nil, fmt.Errorf("invalid lognormal parameters %q", args)
+		}
+		if maxSize <= 0 {
+			return nil, fmt.Errorf("invalid max size %d for lognormal sizes", maxSize)
+		}
+		return LogNormal{Mu: mu, Sigma: sigma, Max: maxSize}, nil
+	case "histogram":
+		return LoadHistogram(args)
+	default:
+		return nil, fmt.Errorf("unknown size distribution %q", kind)
+	}
+}
+
+// Fixed always returns the same size.
+type Fixed int
+
+func (f Fixed) Size(*rand.Rand) int {
+	return int(f)
+}
+
+// Uniform returns sizes evenly distributed between Min and Max inclusive.
+type Uniform struct {
+	Min int
+	Max int
+}
+
+func (u Uniform) Size(r *rand.Rand) int {
+	return u.Min + r.Intn(u.Max-u.Min+1)
+}
+
+// LogNormal returns sizes whose natural log is normally distributed with
+// mean Mu and standard deviation Sigma, capped at Max. A heavy tail would
+// otherwise produce messages far larger than any that are sent in practice.
+type LogNormal struct {
+	Mu    float64
+	Sigma float64
+	Max   int
+}
+
+func (l LogNormal) Size(r *rand.Rand) int {
+	size := math.Round(math.Exp(l.Mu + l.Sigma*r.NormFloat64()))
+	if size > float64(l.Max) {
+		return l.Max
+	}
+
+	return int(size)
+}
+
+// Histogram returns sizes in proportion to their weights.
+type Histogram struct {
+	sizes      []int
+	cumulative []float64
+}
+
+// LoadHistogram reads a histogram from a file with a size in bytes and a
+// weight on each line. Blank lines and lines starting with # are ignored.
+func LoadHistogram(path string) (*Histogram, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	h := &Histogram{}
+	var total float64
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected <bytes> <weight>", path, line)
+		}
+		size, err1 := strconv.Atoi(fields[0])
+		weight, err2 := strconv.ParseFloat(fields[1], 64)
+		if err1 != nil || err2 != nil || size < 0 || weight < 0 {
+			return nil, fmt.Errorf("%s:%d: invalid size or weight", path, line)
+		}
+
+		total += weight
+		h.sizes = append(h.sizes, size)
+		h.cumulative = append(h.cumulative, total)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if total == 0 {
+		return nil, fmt.Errorf("%s: histogram has no weight", path)
+	}
+
+	return h, nil
+}
+
+func (h *Histogram) Size(r *rand.Rand) int {
+	x := r.Float64() * h.cumulative[len(h.cumulative)-1]
+	i := sort.SearchFloat64s(h.cumulative, x)
+	if i >= len(h.sizes) {
+		i = len(h.sizes) - 1
+	}
+
+	return h.sizes[i]
+}
@@ -0,0 +1,111 @@
+package payload_test
+
+import (
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+
+	"code.cloudfoundry.org/log_emitter/internal/payload"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ParseSizer", func() {
+	var r *rand.Rand
+
+	BeforeEach(func() {
+		r = rand.New(rand.NewSource(1))
+	})
+
+	sizes := func(s payload.Sizer, n int) []int {
+		out := make([]int, n)
+		for i := range out {
+			out[i] = s.Size(r)
+		}
+		sort.Ints(out)
+
+		return out
+	}
+
+	It("parses fixed sizes", func() {
+		s, err := payload.ParseSizer("fixed:1000", 100)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(s.Size(r)).To(Equal(1000))
+	})
+
+	It("parses uniform sizes", func() {
+		s, err := payload.ParseSizer("uniform:10-12", 100)
+		Expect(err).ToNot(HaveOccurred())
+
+		seen := make(map[int]bool)
+		for _, size := range sizes(s, 1000) {
+			seen[size] = true
+		}
+		Expect(seen).To(Equal(map[int]bool{10: true, 11: true, 12: true}))
+	})
+
+	It("parses log-normal sizes around the median", func() {
+		s, err := payload.ParseSizer("lognormal:6.9,0.5", 1<<20)
+		Expect(err).ToNot(HaveOccurred())
+
+		out := sizes(s, 10000)
+		Expect(out[len(out)/2]).To(BeNumerically("~", math.Exp(6.9), 50))
+	})
+
+	It("caps log-normal sizes at the max", func() {
+		s, err := payload.ParseSizer("lognormal:10,5", 4096)
+		Expect(err).ToNot(HaveOccurred())
+
+		out := sizes(s, 10000)
+		Expect(out[0]).To(BeNumerically(">=", 0))
+		Expect(out[len(out)-1]).To(Equal(4096))
+	})
+
+	It("caps log-normal sizes that overflow", func() {
+		s := payload.LogNormal{Mu: 1000, Sigma: 0, Max: 4096}
+		Expect(s.Size(r)).To(Equal(4096))
+	})
+
+	It("parses histograms", func() {
+		f, err := ioutil.TempFile("", "histogram")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.Remove(f.Name())
+
+		_, err = f.WriteString("# bytes weight\n100 3\n\n1000 1\n5000 0\n")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(f.Close()).To(Succeed())
+
+		s, err := payload.ParseSizer("histogram:"+f.Name(), 100)
+		Expect(err).ToNot(HaveOccurred())
+
+		counts := make(map[int]int)
+		for _, size := range sizes(s, 10000) {
+			counts[size]++
+		}
+		Expect(counts).To(HaveLen(2))
+		Expect(counts[100]).To(BeNumerically("~", 7500, 250))
+		Expect(counts[1000]).To(BeNumerically("~", 2500, 250))
+	})
+
+	It("rejects invalid specs", func() {
+		for _, spec := range []string{
+			"1000",
+			"fixed:-1",
+			"uniform:10",
+			"uniform:12-10",
+			"lognormal:6.9",
+			"lognormal:6.9,-1",
+			"histogram:/does/not/exist",
+			"poisson:3",
+		} {
+			_, err := payload.ParseSizer(spec, 100)
+			Expect(err).To(HaveOccurred(), spec)
+		}
+
+		_, err := payload.ParseSizer("lognormal:6.9,0.5", 0)
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -1,66 +1,244 @@
 package reader
 
 import (
-	"bytes"
+	"context"
 	"crypto/tls"
 	"log"
-	"sync/atomic"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/cloudfoundry/noaa/consumer"
 	"github.com/cloudfoundry/sonde-go/events"
 
 	"code.cloudfoundry.org/authenticator"
+	"code.cloudfoundry.org/backoff"
+	"code.cloudfoundry.org/datadogreporter"
+	"code.cloudfoundry.org/log_emitter/internal/latency"
+	"code.cloudfoundry.org/log_emitter/internal/message"
 )
 
 type Reader struct {
-	dopplerAddr  string
-	appID        string
-	logMsg       string
-	auth         *authenticator.Authenticator
-	tlsConfig    *tls.Config
-	receivedMsgs int64
+	egress        string
+	read          func(ctx context.Context, authToken string) bool
+	dopplerAddr   string
+	gatewayAddr   string
+	appID         string
+	auth          *authenticator.Authenticator
+	tlsConfig     *tls.Config
+	backoff       *backoff.Backoff
+	receivedBytes datadogreporter.Counter
+	authFailures  datadogreporter.Counter
+	reconnects    datadogreporter.Counter
+	tracker       *message.Tracker
+	latency       latency.Recorder
+
+	mu      sync.Mutex
+	sources map[string]*source
+}
+
+// source counts the messages received from one instance of the app.
+type source struct {
+	cell     string
+	total    int64
+	received datadogreporter.Counter
 }
 
+// New returns a reader that streams the app's logs from doppler's v1
+// websocket endpoint.
 func New(
 	dopplerAddr string,
 	appID string,
-	logMsg string,
 	a *authenticator.Authenticator,
+	tlsConfig *tls.Config,
+	opts ...readerOpt,
+) *Reader {
+	r := newReader("v1", appID, a, tlsConfig, opts)
+	r.dopplerAddr = dopplerAddr
+	r.read = r.readLogs
+
+	return r
+}
+
+func newReader(
+	egress string,
+	appID string,
+	a *authenticator.Authenticator,
+	tlsConfig *tls.Config,
+	opts []readerOpt,
 ) *Reader {
-	return &Reader{
-		dopplerAddr: dopplerAddr,
-		appID:       appID,
-		auth:        a,
-		logMsg:      logMsg,
-		tlsConfig: &tls.Config{
-			InsecureSkipVerify: true,
-		},
+	r := &Reader{
+		egress:    egress,
+		appID:     appID,
+		auth:      a,
+		tlsConfig: tlsConfig,
+		backoff:   backoff.New(time.Second, 2*time.Minute),
+		tracker:   message.NewTracker(),
+		sources:   make(map[string]*source),
+	}
+
+	for _, o := range opts {
+		o(r)
+	}
+
+	return r
+}
+
+type readerOpt func(*Reader)
+
+// WithSources expects messages from instances of the app, so that their
+// received counts are reported as zero rather than not at all when none of
+// their messages arrive. Sources maps each instance to its cell, which may
+// be empty if it is not known; it is filled in from the first message
+// received.
+func WithSources(sources map[string]string) readerOpt {
+	return func(r *Reader) {
+		for instance, cell := range sources {
+			r.sources[instance] = &source{cell: cell}
+		}
+	}
+}
+
+// Egress names the path the reader receives logs through: v1 or rlp.
+func (r *Reader) Egress() string {
+	return r.egress
+}
+
+// ReceivedPoints returns the number of log messages received from every
+// instance of the app, tagged with source_instance, the instance's cell
+// and the given tags.
+func (r *Reader) ReceivedPoints(w datadogreporter.Window, tags []string) []datadogreporter.Point {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	names := make([]string, 0, len(r.sources))
+	for name := range r.sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var points []datadogreporter.Point
+	for _, name := range names {
+		s := r.sources[name]
+		points = append(points, datadogreporter.Point{
+			Metric: "capacity_planning.received",
+			Points: s.received.Samples(w),
+			Type:   "gauge",
+			Tags:   append(SourceTags(name, s.cell), tags...),
+		})
+	}
+
+	return points
+}
+
+// ReceivedFrom returns the total number of log messages received from an
+// instance of the app.
+func (r *Reader) ReceivedFrom(instance string) int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s, ok := r.sources[instance]
+	if !ok {
+		return 0
 	}
+
+	return s.total
 }
 
-func (r *Reader) Count() int64 {
-	return atomic.SwapInt64(&r.receivedMsgs, 0)
+// Received returns the total number of log messages received from every
+// instance of the app.
+func (r *Reader) Received() int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var total int64
+	for _, s := range r.sources {
+		total += s.total
+	}
+
+	return total
 }
 
-func (r *Reader) Run() {
+// SourceTags returns the tags that identify an instance of the app and the
+// cell it runs on.
+func SourceTags(instance, cell string) []string {
+	tags := []string{"source_instance:" + instance}
+	if cell != "" {
+		tags = append(tags, "cell:"+cell)
+	}
+
+	return tags
+}
+
+// ByteSamples returns the number of bytes of log messages received.
+func (r *Reader) ByteSamples(w datadogreporter.Window) [][]int64 {
+	return r.receivedBytes.Samples(w)
+}
+
+// AuthFailureSamples returns the number of failed attempts to get a token.
+func (r *Reader) AuthFailureSamples(w datadogreporter.Window) [][]int64 {
+	return r.authFailures.Samples(w)
+}
+
+// ReconnectSamples returns the number of times the stream was reconnected.
+func (r *Reader) ReconnectSamples(w datadogreporter.Window) [][]int64 {
+	return r.reconnects.Samples(w)
+}
+
+// SequencePoints returns the lost, duplicated and reordered messages from
+// every instance of the app.
+func (r *Reader) SequencePoints(w datadogreporter.Window, tags []string) []datadogreporter.Point {
+	return r.tracker.BuildPoints(w, tags)
+}
+
+// LatencyPoints returns percentiles of the delay between writing and
+// receiving messages. Messages from other instances are stamped with their
+// clocks, so the delays include any clock skew between the cells.
+func (r *Reader) LatencyPoints(w datadogreporter.Window, tags []string) []datadogreporter.Point {
+	return r.latency.BuildPoints(w, tags)
+}
+
+// Run reads the app's logs until the context is done, reconnecting with
+// backoff whenever the stream fails.
+func (r *Reader) Run(ctx context.Context) {
 	for {
 		token, err := r.auth.Token()
 		if err != nil {
+			r.authFailures.Add(1)
+			if authenticator.IsCredentialsError(err) {
+				r.backoff.Max()
+			}
 			log.Printf("failed to authenticate with UAA: %s", err)
-			time.Sleep(time.Second)
-			continue
+		} else if r.read(ctx, token) {
+			r.backoff.Reset()
 		}
 
-		r.readLogs(token)
+		if ctx.Err() != nil || !r.backoff.Wait(ctx) {
+			return
+		}
+		r.reconnects.Add(1)
 	}
 }
 
-func (r *Reader) readLogs(authToken string) {
+// readLogs counts log messages until the stream ends or the context is
+// done. It returns true if any envelopes were received.
+func (r *Reader) readLogs(ctx context.Context, authToken string) bool {
 	cmr := consumer.New(r.dopplerAddr, r.tlsConfig, nil)
 
+	cmr.RefreshTokenFrom(r.auth)
+
 	msgChan, errChan := cmr.Stream(r.appID, authToken)
 
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			cmr.Close()
+		case <-done:
+		}
+	}()
+
 	go func() {
 		for err := range errChan {
 			if err == nil {
@@ -71,16 +249,56 @@ func (r *Reader) readLogs(authToken string) {
 		}
 	}()
 
+	var received bool
 	for msg := range msgChan {
 		if msg == nil {
-			return
+			return received
 		}
+		received = true
 
 		if msg.GetEventType() == events.Envelope_LogMessage {
-			log := msg.GetLogMessage()
-			if bytes.Contains(log.GetMessage(), []byte(r.logMsg)) {
-				atomic.AddInt64(&r.receivedMsgs, 1)
-			}
+			logMsg := msg.GetLogMessage()
+			r.observe(logMsg.GetMessage(), logMsg.GetSourceInstance())
 		}
 	}
+
+	return received
+}
+
+// observe counts a log message received from an instance of the app.
+// Messages that were not written by log_emitter are ignored.
+func (r *Reader) observe(body []byte, sourceInstance string) {
+	h, ok := message.Parse(body)
+	if !ok {
+		return
+	}
+
+	r.receivedBytes.Add(int64(len(body)))
+	r.tracker.Observe(h)
+	r.countSource(sourceInstance, h)
+	if !h.SentAt.IsZero() {
+		r.latency.Record(time.Since(h.SentAt))
+	}
+}
+
+// countSource attributes a message to the instance named by its envelope,
+// falling back to the instance in its header.
+func (r *Reader) countSource(instance string, h message.Header) {
+	if instance == "" {
+		instance = h.Instance
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s, ok := r.sources[instance]
+	if !ok {
+		s = &source{}
+		r.sources[instance] = s
+	}
+	if h.Cell != "" {
+		s.cell = h.Cell
+	}
+	s.total++
+	s.received.Add(1)
 }
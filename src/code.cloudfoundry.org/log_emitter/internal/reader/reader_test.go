@@ -0,0 +1,176 @@
+package reader_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/authenticator"
+	"code.cloudfoundry.org/datadogreporter"
+	"code.cloudfoundry.org/log_emitter/internal/message"
+	"code.cloudfoundry.org/log_emitter/internal/payload"
+	"code.cloudfoundry.org/log_emitter/internal/reader"
+	"github.com/cloudfoundry/sonde-go/events"
+	"github.com/gogo/protobuf/proto"
+	"github.com/gorilla/websocket"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Reader", func() {
+	var (
+		doppler   *httptest.Server
+		envelopes chan []byte
+		cancel    context.CancelFunc
+	)
+
+	BeforeEach(func() {
+		// The handler outlives the server, since websocket connections are
+		// hijacked, so it must not share the variable with later specs.
+		ch := make(chan []byte, 100)
+		envelopes = ch
+		doppler = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			upgrader := websocket.Upgrader{
+				CheckOrigin: func(*http.Request) bool { return true },
+			}
+			ws, err := upgrader.Upgrade(w, r, nil)
+			if err != nil {
+				return
+			}
+			defer ws.Close()
+
+			closed := make(chan struct{})
+			go func() {
+				defer close(closed)
+				for {
+					if _, _, err := ws.ReadMessage(); err != nil {
+						return
+					}
+				}
+			}()
+
+			for {
+				select {
+				case e := <-ch:
+					err := ws.WriteMessage(websocket.BinaryMessage, e)
+					if err != nil {
+						return
+					}
+				case <-closed:
+					return
+				}
+			}
+		}))
+	})
+
+	AfterEach(func() {
+		cancel()
+		doppler.Close()
+	})
+
+	run := func() *reader.Reader {
+		auth := authenticator.New("", "", "", authenticator.WithStaticToken("bearer token"))
+		addr := strings.Replace(doppler.URL, "http://", "ws://", 1)
+		r := reader.New(addr, "app-id", auth, nil, reader.WithSources(map[string]string{
+			"0": "10.0.0.1",
+			"2": "",
+		}))
+
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(context.Background())
+		go r.Run(ctx)
+
+		return r
+	}
+
+	receivedFrom := func(r *reader.Reader, instance string) func() int64 {
+		return func() int64 {
+			return r.ReceivedFrom(instance)
+		}
+	}
+
+	It("attributes messages to the instance that sent them", func() {
+		r := run()
+
+		envelopes <- logEnvelope("0", "0", "10.0.0.1")
+		envelopes <- logEnvelope("0", "0", "10.0.0.1")
+		envelopes <- logEnvelope("1", "1", "10.0.0.2")
+		Eventually(receivedFrom(r, "1")).Should(Equal(int64(1)))
+
+		Expect(r.ReceivedFrom("0")).To(Equal(int64(2)))
+	})
+
+	It("falls back to the instance in the message header", func() {
+		r := run()
+
+		envelopes <- logEnvelope("", "1", "10.0.0.2")
+		Eventually(receivedFrom(r, "1")).Should(Equal(int64(1)))
+	})
+
+	It("ignores messages that log_emitter did not write", func() {
+		r := run()
+
+		envelopes <- envelope("0", []byte("hello"))
+		envelopes <- logEnvelope("0", "0", "10.0.0.1")
+		Eventually(receivedFrom(r, "0")).Should(Equal(int64(1)))
+		Consistently(receivedFrom(r, "0"), "100ms").Should(Equal(int64(1)))
+	})
+
+	It("reports zero received for expected instances that sent nothing", func() {
+		r := run()
+
+		envelopes <- logEnvelope("1", "1", "10.0.0.2")
+		Eventually(receivedFrom(r, "1")).Should(Equal(int64(1)))
+
+		end := time.Now().Truncate(time.Second).Add(time.Second)
+		points := r.ReceivedPoints(datadogreporter.Window{Start: end.Add(-time.Minute), End: end}, []string{"app"})
+
+		totals := make(map[string]int64)
+		for _, p := range points {
+			Expect(p.Metric).To(Equal("capacity_planning.received"))
+			totals[fmt.Sprint(p.Tags)] += sum(p.Points)
+		}
+		Expect(totals).To(Equal(map[string]int64{
+			"[source_instance:0 cell:10.0.0.1 app]": 0,
+			"[source_instance:1 cell:10.0.0.2 app]": 1,
+			"[source_instance:2 app]":               0,
+		}))
+	})
+})
+
+// logEnvelope returns a marshalled log envelope from sourceInstance holding
+// a message written by instance on cell.
+func logEnvelope(sourceInstance, instance, cell string) []byte {
+	msg := message.NewEncoder("run", instance, cell, payload.Fixed(0), nil).Next()
+
+	return envelope(sourceInstance, append([]byte(nil), msg...))
+}
+
+func envelope(sourceInstance string, msg []byte) []byte {
+	e, err := proto.Marshal(&events.Envelope{
+		Origin:    proto.String("test"),
+		EventType: events.Envelope_LogMessage.Enum(),
+		LogMessage: &events.LogMessage{
+			Message:        msg,
+			MessageType:    events.LogMessage_OUT.Enum(),
+			Timestamp:      proto.Int64(time.Now().UnixNano()),
+			SourceInstance: proto.String(sourceInstance),
+		},
+	})
+	Expect(err).ToNot(HaveOccurred())
+
+	return e
+}
+
+func sum(samples [][]int64) int64 {
+	var total int64
+	for _, s := range samples {
+		total += s[1]
+	}
+
+	return total
+}
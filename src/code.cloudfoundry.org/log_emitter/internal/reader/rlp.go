@@ -0,0 +1,176 @@
+package reader
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"code.cloudfoundry.org/authenticator"
+	"code.cloudfoundry.org/log_emitter/internal/message"
+)
+
+// NewRLP returns a reader that streams the app's logs from the reverse log
+// proxy gateway's /v2/read server-sent events endpoint.
+func NewRLP(
+	gatewayAddr string,
+	appID string,
+	a *authenticator.Authenticator,
+	tlsConfig *tls.Config,
+	opts ...readerOpt,
+) *Reader {
+	r := newReader("rlp", appID, a, tlsConfig, opts)
+	r.gatewayAddr = gatewayAddr
+
+	// The client is shared by every connection so that reconnects can
+	// reuse it. The stream is long lived, so only the wait for headers is
+	// bounded.
+	client := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig:       tlsConfig,
+			ResponseHeaderTimeout: 30 * time.Second,
+		},
+	}
+	r.read = func(ctx context.Context, authToken string) bool {
+		return r.readRLP(ctx, client, authToken)
+	}
+
+	return r
+}
+
+// rlpBatch is a batch of v2 envelopes as encoded by the gateway. Only the
+// fields of log envelopes that are counted are decoded.
+type rlpBatch struct {
+	Batch []struct {
+		InstanceID string `json:"instance_id"`
+		Log        *struct {
+			Payload string `json:"payload"`
+		} `json:"log"`
+	} `json:"batch"`
+}
+
+// readRLP counts log messages until the stream ends or the context is
+// done. It returns true if any envelopes were received; heartbeats do not
+// count, so that a stream without data still backs off.
+func (r *Reader) readRLP(ctx context.Context, client *http.Client, authToken string) bool {
+	q := url.Values{}
+	q.Set("source_id", r.appID)
+	q.Set("log", "")
+	// Every reader needs its own shard to receive all of the envelopes.
+	q.Set("shard_id", "capacity-planning-"+message.NewRunID())
+
+	req, err := http.NewRequest(http.MethodGet, r.gatewayAddr+"/v2/read?"+q.Encode(), nil)
+	if err != nil {
+		log.Printf("failed to build RLP gateway request: %s", err)
+		return false
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Authorization", authToken)
+	req.Header.Set("Accept", "text/event-stream")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Printf("failed to connect to RLP gateway: %s", err)
+		return false
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		log.Printf("RLP gateway returned %d: %s", resp.StatusCode, body)
+		if resp.StatusCode == http.StatusUnauthorized {
+			_, err := r.auth.RefreshAuthToken()
+			if err != nil {
+				r.authFailures.Add(1)
+				log.Printf("failed to refresh token: %s", err)
+			}
+		}
+		return false
+	}
+
+	var received bool
+	err = readEvents(resp.Body, func(event string, data []byte) {
+		switch event {
+		case "heartbeat":
+			return
+		case "closing":
+			log.Printf("RLP gateway is closing the stream: %s", data)
+			return
+		}
+
+		var b rlpBatch
+		err := json.Unmarshal(data, &b)
+		if err != nil {
+			log.Printf("failed to unmarshal envelope batch: %s", err)
+			return
+		}
+		if len(b.Batch) > 0 {
+			received = true
+		}
+
+		for _, e := range b.Batch {
+			if e.Log == nil {
+				continue
+			}
+
+			body, err := base64.StdEncoding.DecodeString(e.Log.Payload)
+			if err != nil {
+				continue
+			}
+			r.observe(body, e.InstanceID)
+		}
+	})
+	if err != nil && ctx.Err() == nil {
+		log.Printf("RLP gateway stream failed: %s", err)
+	}
+
+	return received
+}
+
+// readEvents calls f with the name and data of every server-sent event on
+// the stream. Events without a name are passed with an empty name.
+func readEvents(body io.Reader, f func(event string, data []byte)) error {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	var (
+		event string
+		data  bytes.Buffer
+	)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+
+		switch {
+		case len(line) == 0:
+			if data.Len() > 0 {
+				f(event, data.Bytes())
+			}
+			event = ""
+			data.Reset()
+		case line[0] == ':':
+			// Comment.
+		case bytes.HasPrefix(line, []byte("event:")):
+			event = string(bytes.TrimSpace(line[len("event:"):]))
+		case bytes.HasPrefix(line, []byte("data:")):
+			if data.Len() > 0 {
+				data.WriteByte('\n')
+			}
+			data.Write(bytes.TrimPrefix(line[len("data:"):], []byte(" ")))
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read events: %s", err)
+	}
+
+	return nil
+}
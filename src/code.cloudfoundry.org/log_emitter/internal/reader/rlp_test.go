@@ -0,0 +1,142 @@
+package reader_test
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+
+	"code.cloudfoundry.org/authenticator"
+	"code.cloudfoundry.org/log_emitter/internal/message"
+	"code.cloudfoundry.org/log_emitter/internal/payload"
+	"code.cloudfoundry.org/log_emitter/internal/reader"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RLP", func() {
+	var (
+		mu       sync.Mutex
+		requests []*http.Request
+		fixture  string
+		gateway  *httptest.Server
+		cancel   context.CancelFunc
+	)
+
+	BeforeEach(func() {
+		requests = nil
+		gateway = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			requests = append(requests, r)
+			mu.Unlock()
+
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprint(w, fixture)
+		}))
+	})
+
+	AfterEach(func() {
+		cancel()
+		gateway.Close()
+	})
+
+	run := func() *reader.Reader {
+		auth := authenticator.New("", "", "", authenticator.WithStaticToken("token"))
+		r := reader.NewRLP(gateway.URL, "app-id", auth, nil)
+
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(context.Background())
+		go r.Run(ctx)
+
+		return r
+	}
+
+	connections := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(requests)
+	}
+
+	It("requests the app's logs with the token", func() {
+		fixture = ""
+		run()
+		Eventually(connections).Should(BeNumerically(">=", 1))
+
+		mu.Lock()
+		req := requests[0]
+		mu.Unlock()
+
+		Expect(req.URL.Path).To(Equal("/v2/read"))
+		q, err := url.ParseQuery(req.URL.RawQuery)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(q.Get("source_id")).To(Equal("app-id"))
+		Expect(q).To(HaveKey("log"))
+		Expect(q.Get("shard_id")).To(HavePrefix("capacity-planning-"))
+		Expect(req.Header.Get("Authorization")).To(Equal("bearer token"))
+		Expect(req.Header.Get("Accept")).To(Equal("text/event-stream"))
+	})
+
+	It("counts the logs in every batch and skips other events", func() {
+		fixture = ": comment\n\n" +
+			"event: heartbeat\ndata: 1\n\n" +
+			batch(rlpLogEnvelope("0", "0", ""), rlpLogEnvelope("1", "1", "")) +
+			"event: closing\ndata: shutting down\n\n" +
+			batch(`{"instance_id":"0","counter":{"name":"requests"}}`, rlpLogEnvelope("0", "0", ""))
+		r := run()
+
+		Eventually(r.Received).Should(Equal(int64(3)))
+	})
+
+	It("reassembles data split over several lines", func() {
+		fixture = "data: {\"batch\":[\ndata: " + rlpLogEnvelope("0", "0", "") + "\ndata: ]}\n\n"
+		r := run()
+
+		Eventually(r.Received).Should(BeNumerically(">=", 1))
+	})
+
+	It("backs off when the stream only has heartbeats", func() {
+		fixture = "event: heartbeat\ndata: 1\n\n"
+		run()
+
+		Eventually(connections, "2s").Should(BeNumerically(">=", 2))
+		Consistently(connections, "3400ms").Should(BeNumerically("<=", 3))
+	})
+
+	It("reconnects over the same connection when the stream ends", func() {
+		fixture = batch(rlpLogEnvelope("0", "0", ""))
+		r := run()
+
+		Eventually(connections, "5s").Should(BeNumerically(">=", 2))
+		Eventually(r.Received).Should(BeNumerically(">=", 2))
+
+		mu.Lock()
+		defer mu.Unlock()
+		Expect(requests[1].RemoteAddr).To(Equal(requests[0].RemoteAddr))
+	})
+})
+
+// batch returns a server-sent event holding a batch of envelopes.
+func batch(envelopes ...string) string {
+	var b []json.RawMessage
+	for _, e := range envelopes {
+		b = append(b, json.RawMessage(e))
+	}
+
+	data, err := json.Marshal(map[string]interface{}{"batch": b})
+	Expect(err).ToNot(HaveOccurred())
+
+	return fmt.Sprintf("data: %s\n\n", data)
+}
+
+// rlpLogEnvelope returns a v2 log envelope from sourceInstance holding a
+// message written by instance on cell.
+func rlpLogEnvelope(sourceInstance, instance, cell string) string {
+	msg := message.NewEncoder("run", instance, cell, payload.Fixed(0), nil).Next()
+
+	return fmt.Sprintf(`{"instance_id":%q,"log":{"payload":%q}}`, sourceInstance, base64.StdEncoding.EncodeToString(msg))
+}
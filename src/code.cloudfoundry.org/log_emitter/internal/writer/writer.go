@@ -1,39 +1,113 @@
 package writer
 
 import (
-	"fmt"
+	"bufio"
+	"context"
+	"log"
+	"os"
+	"sync"
 	"sync/atomic"
-	"time"
+
+	"code.cloudfoundry.org/datadogreporter"
+	"code.cloudfoundry.org/log_emitter/internal/message"
+	"code.cloudfoundry.org/log_emitter/internal/payload"
+	"code.cloudfoundry.org/pacer"
 )
 
 type Writer struct {
-	logMsg        string
-	sentMsgs      int64
-	logsPerSecond uint
+	sent      int64
+	mu        sync.Mutex
+	encoder   *message.Encoder
+	sentMsgs  datadogreporter.Counter
+	sentBytes datadogreporter.Counter
+	pacer     *pacer.Pacer
+	out       *bufio.Writer
 }
 
-func New(logMsg string, logsPerSecond uint) *Writer {
+// New returns a writer of sequence numbered messages, identified by the
+// instance index and cell, with sizes and content from the given payload.
+func New(instance, cell string, sizer payload.Sizer, content payload.Content, logsPerSecond float64) *Writer {
 	return &Writer{
-		logMsg:        logMsg,
-		logsPerSecond: logsPerSecond,
+		encoder: message.NewEncoder(message.NewRunID(), instance, cell, sizer, content),
+		pacer:   pacer.New(logsPerSecond),
+		out:     bufio.NewWriterSize(os.Stdout, 64*1024),
 	}
 }
 
-func (w *Writer) Count() int64 {
-	return atomic.SwapInt64(&w.sentMsgs, 0)
+func (w *Writer) Samples(window datadogreporter.Window) [][]int64 {
+	return w.sentMsgs.Samples(window)
+}
+
+// ByteSamples returns the number of bytes of log messages written, not
+// counting newlines.
+func (w *Writer) ByteSamples(window datadogreporter.Window) [][]int64 {
+	return w.sentBytes.Samples(window)
+}
+
+// Sent returns the total number of log messages written.
+func (w *Writer) Sent() int64 {
+	return atomic.LoadInt64(&w.sent)
+}
+
+// TargetRate returns the rate, in logs per second, that the writer is
+// trying to achieve.
+func (w *Writer) TargetRate() float64 {
+	return w.pacer.Rate()
+}
+
+// SetRate changes the rate, in logs per second, that the writer is trying
+// to achieve.
+func (w *Writer) SetRate(logsPerSecond float64) {
+	w.pacer.SetRate(logsPerSecond)
 }
 
-func (w *Writer) Run() {
-	interval := time.Second / time.Duration(w.logsPerSecond)
-	for {
-		startTime := time.Now()
-		w.emitLog()
-		timeToSleep := interval - time.Since(startTime)
-		time.Sleep(timeToSleep)
+func (w *Writer) Run(ctx context.Context) {
+	w.pacer.Run(ctx, w.emitLogs)
+}
+
+// SetPayload changes the sizes and content of the log messages that are
+// written from the next batch on.
+func (w *Writer) SetPayload(sizer payload.Sizer, content payload.Content) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.encoder.SetPayload(sizer, content)
+}
+
+// emitLogs writes a batch of n log lines with as few writes as the buffer
+// allows. A batch that fails to write is not counted as sent.
+func (w *Writer) emitLogs(n int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var bytes int64
+	for i := 0; i < n; i++ {
+		msg := w.encoder.Next()
+		bytes += int64(len(msg))
+
+		_, err := w.out.Write(msg)
+		if err == nil {
+			err = w.out.WriteByte('\n')
+		}
+		if err != nil {
+			w.writeFailed(err)
+			return
+		}
+	}
+
+	err := w.out.Flush()
+	if err != nil {
+		w.writeFailed(err)
+		return
 	}
+	atomic.AddInt64(&w.sent, int64(n))
+	w.sentMsgs.Add(int64(n))
+	w.sentBytes.Add(bytes)
 }
 
-func (w *Writer) emitLog() {
-	atomic.AddInt64(&w.sentMsgs, 1)
-	fmt.Printf("%s\n", w.logMsg)
+// writeFailed logs the error and discards the buffered logs. Errors are
+// sticky in a bufio.Writer, so it is reset for the next batch to try again.
+func (w *Writer) writeFailed(err error) {
+	log.Printf("failed to write logs: %s", err)
+	w.out.Reset(os.Stdout)
 }
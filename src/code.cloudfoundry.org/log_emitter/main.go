@@ -1,42 +1,31 @@
 package main
 
 import (
-	"crypto/tls"
 	"encoding/json"
 	"flag"
 	"fmt"
-	"io/ioutil"
 	"log"
+	"math"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"code.cloudfoundry.org/authenticator"
 	"code.cloudfoundry.org/datadogreporter"
+	"code.cloudfoundry.org/loadprofile"
+	"code.cloudfoundry.org/log_emitter/internal/endpoints"
+	"code.cloudfoundry.org/log_emitter/internal/logcache"
+	"code.cloudfoundry.org/log_emitter/internal/payload"
 	"code.cloudfoundry.org/log_emitter/internal/reader"
 	"code.cloudfoundry.org/log_emitter/internal/writer"
+	"code.cloudfoundry.org/ratesearch"
+	"code.cloudfoundry.org/shutdown"
+	"code.cloudfoundry.org/tlsclient"
 )
 
-var (
-	logMessage       string
-	messagesSent     int64
-	messagesReceived int64
-
-	tlsConfig *tls.Config = &tls.Config{
-		InsecureSkipVerify: true,
-	}
-
-	httpClient *http.Client = &http.Client{
-		Timeout: 30 * time.Second,
-		Transport: &http.Transport{
-			TLSClientConfig: tlsConfig,
-		},
-	}
-
-	reportReadMessages bool
-)
-
-const datadogAddr = "https://app.datadoghq.com/api/v1/series"
+var reportReadMessages bool
 
 type VCAPApplication struct {
 	APIAddr string `json:"cf_api"`
@@ -44,136 +33,449 @@ type VCAPApplication struct {
 	AppName string `json:"application_name"`
 }
 
-type V2Info struct {
-	DopplerAddr string `json:"doppler_logging_endpoint"`
-	UAAAddr     string `json:"token_endpoint"`
-}
-
 type AuthInfo struct {
 	ClientID     string
 	ClientSecret string
+	Username     string
+	Password     string
+	RefreshToken string
+	Token        string
 }
 
 func main() {
-	logsPerSecond := flag.Uint("logs-per-second", 1000, "Log messages to emit per second. Default: 1000")
-	logSize := flag.Uint("log-bytes", 1000, "Length of log messages in bytes. Default: 1000")
+	logsPerSecond := flag.Float64("logs-per-second", 1000, "Log messages to emit per second. May be fractional; 0 pauses emission. Default: 1000")
+	logSize := flag.Uint("log-bytes", 1000, "Length of log messages in bytes, including the sequence header. Default: 1000")
+	sizeDistribution := flag.String("log-size-distribution", "", "Distribution of log message sizes: fixed:<bytes>, uniform:<min>-<max>, lognormal:<mu>,<sigma> or histogram:<path>. Overrides log-bytes.")
+	maxLogSize := flag.Int("log-max-bytes", 64*1024, "Largest log message in bytes that lognormal size distributions produce.")
+	logContent := flag.String("log-content", "fill", "Content of log messages: fill, text or random.")
+	loadProfile := flag.String("load-profile", "", "Profile that varies logs-per-second over time, e.g. ramp:100,10000,30m. See the loadprofile package for the formats.")
 	datadogAPIKey := flag.String("datadog-api-key", "", "Datadog API key for emitting metrics.")
+	sinks := flag.String("sink", "datadog", "Comma separated list of sinks for metrics: datadog, statsd:<addr>, stdout, file:<path> or prometheus:<addr>.")
+	spoolPath := flag.String("datadog-spool-path", "", "File for holding Datadog series that have not been submitted.")
+	datadogSite := flag.String("datadog-site", "", "Datadog site to send metrics to, e.g. datadoghq.eu. Defaults to the US1 site.")
+	datadogAPIVersion := flag.String("datadog-api-version", "v1", "Version of the Datadog series API: v1 or v2.")
+	datadogCompress := flag.Bool("datadog-compress", false, "Gzip requests to Datadog.")
+	drainTimeout := flag.Duration("drain-timeout", 5*time.Second, "Time to keep counting after emitters stop on shutdown.")
+	resolution := flag.String("report-resolution", "interval", "How per-second counts are reported: interval, second or summary.")
+	egress := flag.String("egress", "v1", "Comma separated paths to read logs through: v1 (doppler websocket) and rlp (RLP gateway). Received points are tagged with egress; group by it when reading through both.")
+	instances := flag.Int("instances", 1, "Number of instances of the app. Instances that no messages are received from are reported as receiving zero.")
+	verifyLogCache := flag.Bool("log-cache", false, "Count the app's messages stored by Log Cache as capacity_planning.cached.")
+	logCacheInterval := flag.Duration("log-cache-interval", 30*time.Second, "How often to read Log Cache. Must be short enough that envelopes are read before Log Cache evicts them.")
+	logCacheLag := flag.Duration("log-cache-lag", 10*time.Second, "How far behind the current time Log Cache reads stop, to allow for envelopes still being written.")
+
+	controlAddrFlag := flag.String("control-addr", "", "Address to serve the control API on, for changing the rate and payload at runtime. Defaults to $PORT when control-token is set, otherwise the API is disabled. Only loopback addresses are served without a token.")
+	controlToken := flag.String("control-token", "", "Bearer token required to change anything through the control API.")
+	controlMaxRate := flag.Float64("control-max-rate", 100000, "Highest logs-per-second that can be set through the control API. 0 is unbounded.")
+	search := flag.Bool("search", false, "Search for the highest logs-per-second with loss under the threshold. Runs on instance 0; other instances emit at logs-per-second.")
+	var searchCfg ratesearch.Config
+	flag.Float64Var(&searchCfg.MinRate, "search-min-rate", 100, "First rate tried by the search.")
+	flag.Float64Var(&searchCfg.MaxRate, "search-max-rate", 0, "Highest rate tried by the search. 0 is unbounded.")
+	flag.Float64Var(&searchCfg.LossThreshold, "search-loss-threshold", 0.1, "Highest loss, as a percentage, of a sustainable rate.")
+	flag.DurationVar(&searchCfg.Soak, "search-soak", 5*time.Minute, "How long each rate is held while counting loss.")
+	flag.DurationVar(&searchCfg.Settle, "search-settle", 30*time.Second, "How long each rate is held before counting loss.")
+	flag.Float64Var(&searchCfg.Precision, "search-precision", 0.05, "Fraction of the rate that the search narrows down to before stopping.")
+	flag.IntVar(&searchCfg.MaxSteps, "search-max-steps", 50, "Most rates the search tries before stopping with the best rate so far.")
 
 	var authInfo AuthInfo
 	flag.StringVar(&authInfo.ClientID, "client-id", "", "ID of client used for authentication.")
 	flag.StringVar(&authInfo.ClientSecret, "client-secret", "", "Secret used for authentication.")
+	flag.StringVar(&authInfo.Username, "username", "", "User to authenticate as with the password grant instead of client credentials.")
+	flag.StringVar(&authInfo.Password, "password", "", "Password for the user.")
+	flag.StringVar(&authInfo.RefreshToken, "refresh-token", "", "Refresh token to authenticate with instead of client credentials.")
+	flag.StringVar(&authInfo.Token, "auth-token", "", "Bearer token to use instead of authenticating with UAA. It is never refreshed.")
+
+	var tlsFlags tlsclient.Config
+	flag.StringVar(&tlsFlags.CAPath, "ca-path", "", "Path to CA certificates for verifying the CF API, UAA and doppler. Defaults to the system pool.")
+	flag.StringVar(&tlsFlags.CertPath, "cert-path", "", "Path to a client certificate to present to servers.")
+	flag.StringVar(&tlsFlags.KeyPath, "key-path", "", "Path to the private key for the client certificate.")
+	flag.BoolVar(&tlsFlags.Insecure, "skip-ssl-validation", false, "Do not verify server certificates. Insecure.")
+	flag.StringVar(&tlsFlags.UAAServerName, "uaa-server-name", "", "Server name to verify UAA's certificate against instead of its host name.")
+	flag.StringVar(&tlsFlags.APIServerName, "api-server-name", "", "Server name to verify the CF API's certificate against instead of its host name.")
+	flag.StringVar(&tlsFlags.LoggregatorServerName, "loggregator-server-name", "", "Server name to verify the certificates of doppler, the RLP gateway and Log Cache against instead of their host names.")
+
+	var vcapFlags VCAPApplication
+	flag.StringVar(&vcapFlags.APIAddr, "api-addr", "", "Address of the CF API. Overrides cf_api from VCAP_APPLICATION.")
+	flag.StringVar(&vcapFlags.AppID, "app-id", "", "GUID of the app whose logs are read. Overrides application_id from VCAP_APPLICATION.")
+	flag.StringVar(&vcapFlags.AppName, "app-name", "", "Name of the app to tag metrics with. Overrides application_name from VCAP_APPLICATION.")
+
+	var endpointFlags endpoints.Endpoints
+	flag.StringVar(&endpointFlags.UAA, "uaa-addr", "", "Address of UAA. Overrides the address discovered from the CF API.")
+	flag.StringVar(&endpointFlags.Login, "login-addr", "", "Address of the login server. Overrides the address discovered from the CF API.")
+	flag.StringVar(&endpointFlags.Doppler, "doppler-addr", "", "Address of doppler's websocket endpoint. Overrides the address discovered from the CF API.")
+	flag.StringVar(&endpointFlags.LogStream, "rlp-gateway-addr", "", "Address of the RLP gateway. Overrides the address discovered from the CF API.")
+	flag.StringVar(&endpointFlags.LogCache, "log-cache-addr", "", "Address of Log Cache. Overrides the address discovered from the CF API.")
 
 	flag.Parse()
-	vcapApp := loadVCAP()
 
-	for i := uint(0); i < *logSize; i++ {
-		logMessage += "?"
-	}
-
-	var r *reader.Reader
 	instanceID := os.Getenv("INSTANCE_INDEX")
 	reportReadMessages = instanceID == "0"
+
+	vcapApp, err := loadVCAP(vcapFlags, reportReadMessages)
+	if err != nil {
+		log.Fatalf("%s", err)
+	}
+
+	sink, err := datadogreporter.NewSink(datadogreporter.SinkConfig{
+		Sinks:      *sinks,
+		APIKey:     *datadogAPIKey,
+		SpoolPath:  *spoolPath,
+		Site:       *datadogSite,
+		APIVersion: *datadogAPIVersion,
+		Compress:   *datadogCompress,
+	})
+	if err != nil {
+		log.Fatalf("failed to create metrics sink: %s", err)
+	}
+
+	sizeSpec := fmt.Sprintf("fixed:%d", *logSize)
+	if *sizeDistribution != "" {
+		sizeSpec = *sizeDistribution
+	}
+	sizer, err := payload.ParseSizer(sizeSpec, *maxLogSize)
+	if err != nil {
+		log.Fatalf("invalid log size distribution: %s", err)
+	}
+
+	content, err := payload.ParseContent(*logContent)
+	if err != nil {
+		log.Fatalf("%s", err)
+	}
+
+	var profile loadprofile.Profile
+	if *loadProfile != "" {
+		profile, err = loadprofile.Parse(*loadProfile)
+		if err != nil {
+			log.Fatalf("invalid load profile: %s", err)
+		}
+	}
+
+	if *search && profile != nil {
+		log.Fatalf("search and load-profile cannot be used together")
+	}
+
+	tlsConfigs, err := tlsclient.NewConfigs(tlsFlags)
+	if err != nil {
+		log.Fatalf("failed to build TLS config: %s", err)
+	}
+	if tlsFlags.Insecure {
+		log.Println("WARNING: server certificates will not be verified")
+	}
+
+	emitCtx, countCtx := shutdown.Contexts(*drainTimeout)
+
+	cell := os.Getenv("CF_INSTANCE_IP")
+
+	var (
+		readers  []*reader.Reader
+		verifier *logcache.Verifier
+	)
 	if reportReadMessages {
-		v2Info, err := getV2Info(vcapApp.APIAddr)
+		egresses := strings.Split(*egress, ",")
+		eps := discoverEndpoints(tlsclient.HTTPClient(tlsConfigs.API), vcapApp.APIAddr, endpointFlags)
+
+		var required []string
+		if authInfo.Token == "" {
+			required = append(required, "uaa")
+		}
+		for _, e := range egresses {
+			switch e {
+			case "v1":
+				required = append(required, "logging")
+			case "rlp":
+				required = append(required, "log_stream")
+			default:
+				log.Fatalf("Invalid egress %q, must be v1 or rlp", e)
+			}
+		}
+		if *verifyLogCache {
+			required = append(required, "log_cache")
+		}
+		err = eps.Require(required...)
 		if err != nil {
-			log.Fatalf("failed to get API info: %s", err)
+			log.Fatalf("%s, set them with flags", err)
+		}
+
+		grant := authenticator.WithClientCredentialsGrant()
+		switch {
+		case authInfo.Token != "":
+			grant = authenticator.WithStaticToken(authInfo.Token)
+		case authInfo.RefreshToken != "":
+			grant = authenticator.WithRefreshTokenGrant(authInfo.RefreshToken)
+		case authInfo.Username != "":
+			grant = authenticator.WithPasswordGrant(authInfo.Username, authInfo.Password)
+		}
+
+		clientID := authInfo.ClientID
+		if clientID == "" {
+			clientID = "cf"
 		}
 
 		auth := authenticator.New(
-			authInfo.ClientID,
+			clientID,
 			authInfo.ClientSecret,
-			v2Info.UAAAddr,
+			eps.UAA,
+			authenticator.WithTLSConfig(tlsConfigs.UAA),
+			grant,
 		)
+		checkToken(auth)
 
-		r = reader.New(
-			v2Info.DopplerAddr,
-			vcapApp.AppID,
-			logMessage,
-			auth,
-		)
+		sources := map[string]string{instanceID: cell}
+		for i := 0; i < *instances; i++ {
+			if _, ok := sources[strconv.Itoa(i)]; !ok {
+				sources[strconv.Itoa(i)] = ""
+			}
+		}
+
+		for _, e := range egresses {
+			var r *reader.Reader
+			switch e {
+			case "v1":
+				r = reader.New(eps.Doppler, vcapApp.AppID, auth, tlsConfigs.Loggregator, reader.WithSources(sources))
+			case "rlp":
+				r = reader.NewRLP(eps.LogStream, vcapApp.AppID, auth, tlsConfigs.Loggregator, reader.WithSources(sources))
+			}
+
+			readers = append(readers, r)
+			go r.Run(countCtx)
+		}
 
-		go r.Run()
+		if *verifyLogCache {
+			verifier = logcache.New(
+				eps.LogCache,
+				vcapApp.AppID,
+				auth,
+				tlsConfigs.Loggregator,
+				logcache.WithInterval(*logCacheInterval),
+				logcache.WithLag(*logCacheLag),
+			)
+			go verifier.Run(countCtx)
+		}
 	}
 
-	w := writer.New(logMessage, *logsPerSecond)
-	go w.Run()
+	w := writer.New(instanceID, cell, sizer, content, *logsPerSecond)
+	go w.Run(emitCtx)
+
+	controller := newController(w, readers, *logsPerSecond, sizeSpec, *maxLogSize, *logContent, *controlToken, *controlMaxRate)
+	if addr := controlAddr(*controlAddrFlag, *controlToken); addr != "" {
+		err := controller.ListenAndServe(addr)
+		if err != nil {
+			log.Fatalf("failed to serve the control API: %s", err)
+		}
+	}
+	if profile != nil {
+		go loadprofile.Run(emitCtx, profile, controller, 100*time.Millisecond)
+	}
+
+	var sr *searchReporter
+	if *search && len(readers) > 0 {
+		sr = runSearch(emitCtx, searchCfg, controller, *logsPerSecond, w, readers[0], instanceID)
+	}
 
 	reporter := datadogreporter.New(
 		*datadogAPIKey,
 		vcapApp.AppName,
 		instanceID,
-		NewReportWrapper(vcapApp.AppName, r, w),
+		NewReportWrapper(vcapApp.AppName, reader.SourceTags(instanceID, cell), readers, verifier, w, sr),
 		datadogreporter.WithHost(vcapApp.APIAddr),
+		datadogreporter.WithSink(sink),
+		datadogreporter.WithResolution(*resolution),
 	)
-	reporter.Run()
+	reporter.Run(countCtx)
 }
 
 type ReporterWrapper struct {
-	appName string
-	reader  *reader.Reader
-	writer  *writer.Writer
+	appName    string
+	sourceTags []string
+	readers    []*reader.Reader
+	verifier   *logcache.Verifier
+	writer     *writer.Writer
+	search     *searchReporter
 }
 
-func NewReportWrapper(appName string, r *reader.Reader, w *writer.Writer) *ReporterWrapper {
-	return &ReporterWrapper{appName: appName, reader: r, writer: w}
+// NewReportWrapper returns a point builder for the writer and, on the
+// instance that reads, the readers and Log Cache verifier. Sent points are tagged with sourceTags
+// so that they can be matched with the received points for the instance.
+func NewReportWrapper(
+	appName string,
+	sourceTags []string,
+	readers []*reader.Reader,
+	verifier *logcache.Verifier,
+	w *writer.Writer,
+	s *searchReporter,
+) *ReporterWrapper {
+	return &ReporterWrapper{
+		appName:    appName,
+		sourceTags: sourceTags,
+		readers:    readers,
+		verifier:   verifier,
+		writer:     w,
+		search:     s,
+	}
 }
 
-func (rw *ReporterWrapper) BuildPoints() []datadogreporter.Point {
-	currentTime := time.Now().Unix()
-
-	writeCount := rw.writer.Count()
+func (rw *ReporterWrapper) BuildPoints(w datadogreporter.Window) []datadogreporter.Point {
+	sent := rw.writer.Samples(w)
 	points := []datadogreporter.Point{
 		{
 			Metric: "capacity_planning.sent",
-			Points: [][]int64{{currentTime, writeCount}},
+			Points: sent,
+			Type:   "gauge",
+			Tags:   append([]string{rw.appName, "event_type:logs"}, rw.sourceTags...),
+		},
+		{
+			Metric: "capacity_planning.sent_bytes",
+			Points: rw.writer.ByteSamples(w),
 			Type:   "gauge",
-			Tags:   []string{rw.appName, "event_type:logs"},
+			Tags:   append([]string{rw.appName, "event_type:logs"}, rw.sourceTags...),
 		},
 	}
-
-	if rw.reader != nil {
-		readCount := rw.reader.Count()
+	points = append(points, datadogreporter.RatePoints("capacity_planning.target_rate", w, rw.writer.TargetRate(), []string{rw.appName, "event_type:logs"})...)
+	points = append(points, datadogreporter.Point{
+		Metric:   "capacity_planning.achieved_rate",
+		Points:   [][]int64{{w.Start.Unix(), achievedRate(sent)}},
+		Type:     "gauge",
+		Tags:     []string{rw.appName, "event_type:logs"},
+		Absolute: true,
+	})
+
+	for _, r := range rw.readers {
+		egress := "egress:" + r.Egress()
+		tags := []string{rw.appName, "event_type:logs", egress}
+
+		points = append(points, r.ReceivedPoints(w, tags)...)
 		points = append(points, datadogreporter.Point{
-			Metric: "capacity_planning.received",
-			Points: [][]int64{{currentTime, readCount}},
+			Metric: "capacity_planning.received_bytes",
+			Points: r.ByteSamples(w),
+			Type:   "gauge",
+			Tags:   tags,
+		}, datadogreporter.Point{
+			Metric: "capacity_planning.reader.auth_failures",
+			Points: r.AuthFailureSamples(w),
 			Type:   "gauge",
-			Tags:   []string{rw.appName, "event_type:logs"},
+			Tags:   []string{rw.appName, egress},
+		}, datadogreporter.Point{
+			Metric: "capacity_planning.reader.reconnects",
+			Points: r.ReconnectSamples(w),
+			Type:   "gauge",
+			Tags:   []string{rw.appName, egress},
 		})
+		points = append(points, r.SequencePoints(w, tags)...)
+		points = append(points, r.LatencyPoints(w, tags)...)
+	}
+
+	if rw.verifier != nil {
+		points = append(points, rw.verifier.BuildPoints(w, []string{rw.appName, "event_type:logs"})...)
+	}
+
+	if rw.search != nil {
+		points = append(points, rw.search.BuildPoints(w, []string{rw.appName, "event_type:logs"})...)
 	}
 
 	return points
 }
 
-func loadVCAP() *VCAPApplication {
-	var vcapApp VCAPApplication
-	err := json.Unmarshal([]byte(os.Getenv("VCAP_APPLICATION")), &vcapApp)
-	if err != nil {
-		log.Fatalf("failed to unmarshal VCAP_APPLICATION: %s", err)
+// achievedRate returns the mean number of logs per second over the samples.
+func achievedRate(samples [][]int64) int64 {
+	if len(samples) == 0 {
+		return 0
+	}
+
+	var sum int64
+	for _, v := range samples {
+		sum += v[1]
 	}
 
-	return &vcapApp
+	return int64(math.Round(float64(sum) / float64(len(samples))))
 }
 
-func getV2Info(uaaAddr string) (*V2Info, error) {
-	response, err := httpClient.Get(uaaAddr + "/v2/info")
-	if err != nil {
-		return nil, err
+// loadVCAP reads the app's details from VCAP_APPLICATION, replacing any
+// that are set in overrides. A missing or malformed VCAP_APPLICATION is
+// only an error if the overrides do not make up for it. The API address
+// and app ID are only required by the instance that reads.
+func loadVCAP(overrides VCAPApplication, reading bool) (*VCAPApplication, error) {
+	var vcapApp VCAPApplication
+	env := os.Getenv("VCAP_APPLICATION")
+	if env != "" {
+		err := json.Unmarshal([]byte(env), &vcapApp)
+		if err != nil {
+			log.Printf("ignoring malformed VCAP_APPLICATION: %s", err)
+			vcapApp = VCAPApplication{}
+		}
+	}
+
+	if overrides.APIAddr != "" {
+		vcapApp.APIAddr = overrides.APIAddr
 	}
-	if response.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("Expected 200 status code from /v2/info, got %d", response.StatusCode)
+	if overrides.AppID != "" {
+		vcapApp.AppID = overrides.AppID
+	}
+	if overrides.AppName != "" {
+		vcapApp.AppName = overrides.AppName
 	}
 
-	body, err := ioutil.ReadAll(response.Body)
-	response.Body.Close()
-	if err != nil {
-		return nil, err
+	var missing []string
+	if vcapApp.AppName == "" {
+		missing = append(missing, "application_name (app-name)")
+	}
+	if reading && vcapApp.AppID == "" {
+		missing = append(missing, "application_id (app-id)")
 	}
+	if reading && vcapApp.APIAddr == "" {
+		missing = append(missing, "cf_api (api-addr)")
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("VCAP_APPLICATION is missing %s, set them with flags", strings.Join(missing, ", "))
+	}
+
+	return &vcapApp, nil
+}
 
-	var v2Info V2Info
-	err = json.Unmarshal(body, &v2Info)
+// discoverEndpoints discovers endpoints from the CF API, replacing any that
+// are set in overrides. Discovery failures are logged rather than fatal, so
+// that every endpoint can be given with flags when the API is unreachable.
+func discoverEndpoints(httpClient *http.Client, apiAddr string, overrides endpoints.Endpoints) endpoints.Endpoints {
+	var eps endpoints.Endpoints
+	if apiAddr != "" {
+		var err error
+		eps, err = endpoints.Discover(httpClient, apiAddr)
+		if err != nil {
+			log.Printf("%s", err)
+		}
+	}
+
+	eps = eps.Override(overrides)
+	log.Printf("Using endpoints uaa=%q login=%q logging=%q log_stream=%q log_cache=%q",
+		eps.UAA,
+		eps.Login,
+		eps.Doppler,
+		eps.LogStream,
+		eps.LogCache,
+	)
+
+	return eps
+}
+
+// checkToken fails fast when the token cannot be used to read the app's logs. Tokens
+// that cannot be decoded, or fetched yet, are left to the reader to retry.
+func checkToken(auth *authenticator.Authenticator) {
+	claims, err := auth.Claims()
 	if err != nil {
-		return nil, err
+		log.Printf("unable to check token scopes: %s", err)
+		return
 	}
 
-	return &v2Info, nil
+	log.Printf("Authenticated with scopes [%s], audience [%s], expiring at %s",
+		strings.Join(claims.Scopes, " "),
+		strings.Join(claims.Audience, " "),
+		claims.ExpiresAt,
+	)
+
+	if claims.Expired() {
+		log.Fatalf("token expired at %s", claims.ExpiresAt)
+	}
+
+	err = claims.RequireAnyScope("cloud_controller.read", "cloud_controller.admin", "doppler.firehose", "logs.admin")
+	if err != nil {
+		log.Fatalf("%s", err)
+	}
 }
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"math"
+	"sync"
+
+	"code.cloudfoundry.org/datadogreporter"
+	"code.cloudfoundry.org/log_emitter/internal/reader"
+	"code.cloudfoundry.org/log_emitter/internal/writer"
+	"code.cloudfoundry.org/ratesearch"
+)
+
+// searchReporter holds the outcome of a search so that it can be reported
+// once the search has finished.
+type searchReporter struct {
+	mu     sync.Mutex
+	done   bool
+	result ratesearch.Result
+}
+
+// runSearch searches for the highest rate at which this instance's logs
+// are received, logging every step and the result. The rate is set through
+// the rater, so that the controller tracks it, and is left at the rate that
+// was found. If no rate was found it is set back to the configured rate.
+func runSearch(
+	ctx context.Context,
+	cfg ratesearch.Config,
+	rater ratesearch.Rater,
+	rate float64,
+	w *writer.Writer,
+	r *reader.Reader,
+	instanceID string,
+) *searchReporter {
+	counts := func() (int64, int64) {
+		return w.Sent(), r.ReceivedFrom(instanceID)
+	}
+
+	s, err := ratesearch.New(cfg, rater, counts, ratesearch.WithStepHandler(func(s ratesearch.Step) {
+		log.Printf("search: rate %.1f sent %d received %d loss %.4f%% passed %t",
+			s.Rate, s.Sent, s.Received, s.Loss, s.Passed)
+	}))
+	if err != nil {
+		log.Fatalf("invalid search: %s", err)
+	}
+
+	sr := &searchReporter{}
+	go func() {
+		result, err := s.Run(ctx)
+		if result.Rate == 0 {
+			rater.SetRate(rate)
+		}
+
+		switch {
+		case err == ratesearch.ErrMaxSteps:
+			log.Printf("search stopped before reaching its precision: %s", err)
+		case err != nil:
+			log.Printf("search stopped before it finished: %s", err)
+			return
+		case result.Rate == 0:
+			log.Printf("search: min rate %.1f failed, no rate is sustainable", cfg.MinRate)
+		}
+
+		body, err := json.Marshal(result)
+		if err != nil {
+			log.Printf("failed to marshal search result: %s", err)
+		}
+		log.Printf("search: max sustainable rate %.1f logs per second: %s", result.Rate, body)
+
+		sr.mu.Lock()
+		defer sr.mu.Unlock()
+		sr.done = true
+		sr.result = result
+	}()
+
+	return sr
+}
+
+// BuildPoints returns the max sustainable rate once the search has
+// finished.
+func (sr *searchReporter) BuildPoints(w datadogreporter.Window, tags []string) []datadogreporter.Point {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+
+	if !sr.done {
+		return nil
+	}
+
+	return []datadogreporter.Point{
+		{
+			Metric:   "capacity_planning.max_sustainable_rate",
+			Points:   [][]int64{{w.Start.Unix(), int64(math.Round(sr.result.Rate))}},
+			Type:     "gauge",
+			Tags:     tags,
+			Absolute: true,
+		},
+	}
+}
@@ -0,0 +1,389 @@
+package report
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/datadogreporter"
+)
+
+// Builder accumulates sent and received counts per event type and window.
+// Received points are tagged with the egress they were read through, while
+// sent points are not, so the events sent in a group are compared with the
+// events received through each egress separately.
+type Builder struct {
+	sentMetric     string
+	receivedMetric string
+	window         int64
+	groupBy        []string
+
+	sent     map[group]map[int64]int64
+	received map[group]map[int64]int64
+}
+
+// group identifies the points that are reported together. Sent points
+// always have an empty egress.
+type group struct {
+	eventType string
+	source    string
+	egress    string
+}
+
+func NewBuilder(sentMetric, receivedMetric string, window time.Duration, opts ...builderOpt) *Builder {
+	w := int64(window / time.Second)
+	if w < 1 {
+		w = 1
+	}
+
+	b := &Builder{
+		sentMetric:     sentMetric,
+		receivedMetric: receivedMetric,
+		window:         w,
+		sent:           make(map[group]map[int64]int64),
+		received:       make(map[group]map[int64]int64),
+	}
+
+	for _, o := range opts {
+		o(b)
+	}
+
+	return b
+}
+
+type builderOpt func(*Builder)
+
+// WithGroupBy reports loss separately for every combination of values of
+// the given tags within each event type, e.g. source_instance or cell.
+// Points without one of the tags are grouped under a value of (none), so
+// that they are not mistaken for points with the tag. Loss is always
+// reported separately for each egress.
+func WithGroupBy(tagKeys ...string) builderOpt {
+	return func(b *Builder) {
+		b.groupBy = nil
+		for _, k := range tagKeys {
+			if k != "egress" {
+				b.groupBy = append(b.groupBy, k)
+			}
+		}
+	}
+}
+
+// AddPath reads points from a file, or from every file in a directory.
+func (b *Builder) AddPath(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	if !info.IsDir() {
+		return b.addFile(path)
+	}
+
+	return filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		return b.addFile(p)
+	})
+}
+
+func (b *Builder) addFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	err = b.Add(f)
+	if err != nil {
+		return fmt.Errorf("%s: %s", path, err)
+	}
+
+	return nil
+}
+
+// Add reads points written by a JSON sink, one per line. Lines that are not
+// points are skipped.
+func (b *Builder) Add(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		var p datadogreporter.Point
+		if json.Unmarshal([]byte(line), &p) != nil {
+			continue
+		}
+
+		b.AddPoint(p)
+	}
+
+	return scanner.Err()
+}
+
+// AddPoint counts the values of a sent or received point into the windows
+// that contain their timestamps. Points for other metrics are ignored.
+func (b *Builder) AddPoint(p datadogreporter.Point) {
+	g := group{eventType: tagValue(p.Tags, "event_type")}
+	var source []string
+	for _, k := range b.groupBy {
+		v, ok := lookupTag(p.Tags, k)
+		if !ok {
+			v = noTag
+		}
+		source = append(source, k+":"+v)
+	}
+	g.source = strings.Join(source, " ")
+
+	var counts map[group]map[int64]int64
+	switch p.Metric {
+	case b.sentMetric:
+		counts = b.sent
+	case b.receivedMetric:
+		counts = b.received
+		g.egress = tagValue(p.Tags, "egress")
+	default:
+		return
+	}
+
+	windows, ok := counts[g]
+	if !ok {
+		windows = make(map[int64]int64)
+		counts[g] = windows
+	}
+
+	for _, v := range p.Points {
+		if len(v) != 2 {
+			continue
+		}
+
+		windows[v[0]-v[0]%b.window] += v[1]
+	}
+}
+
+// Reports returns a report for every event type, group and egress, sorted
+// by event type, group and egress. A group's sent events are reported once
+// for each egress they were received through, or on their own if none were
+// received.
+func (b *Builder) Reports() []Report {
+	var reports []Report
+	for g, received := range b.received {
+		sent := b.sent[group{eventType: g.eventType, source: g.source}]
+		reports = append(reports, newReport(g, sent, received))
+	}
+	for g, sent := range b.sent {
+		if !b.hasReceived(g) {
+			reports = append(reports, newReport(g, sent, nil))
+		}
+	}
+	sort.Slice(reports, func(i, j int) bool {
+		if reports[i].EventType != reports[j].EventType {
+			return reports[i].EventType < reports[j].EventType
+		}
+		if reports[i].Group != reports[j].Group {
+			return reports[i].Group < reports[j].Group
+		}
+		return reports[i].Egress < reports[j].Egress
+	})
+
+	return reports
+}
+
+// hasReceived returns true if any events of the sent group were received,
+// through any egress.
+func (b *Builder) hasReceived(sent group) bool {
+	for g := range b.received {
+		if g.eventType == sent.eventType && g.source == sent.source {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Window holds the counts for a single window, identified by the unix
+// timestamp of its start.
+type Window struct {
+	Start    int64   `json:"start"`
+	Sent     int64   `json:"sent"`
+	Received int64   `json:"received"`
+	Loss     float64 `json:"loss_percent"`
+}
+
+// Report summarises the loss for a single event type. Loss is the
+// percentage of sent events that were not received. Windows without sent
+// events are listed but do not contribute to the worst window or the
+// percentiles.
+type Report struct {
+	EventType   string             `json:"event_type"`
+	Group       string             `json:"group,omitempty"`
+	Egress      string             `json:"egress,omitempty"`
+	Sent        int64              `json:"sent"`
+	Received    int64              `json:"received"`
+	Loss        float64            `json:"loss_percent"`
+	Worst       *Window            `json:"worst_window,omitempty"`
+	Percentiles map[string]float64 `json:"loss_percentiles,omitempty"`
+	Windows     []Window           `json:"windows"`
+}
+
+func newReport(g group, sent, received map[int64]int64) Report {
+	r := Report{
+		EventType: g.eventType,
+		Group:     g.source,
+		Egress:    g.egress,
+	}
+
+	windows := make(map[int64]*Window)
+	window := func(start int64) *Window {
+		w, ok := windows[start]
+		if !ok {
+			w = &Window{Start: start}
+			windows[start] = w
+		}
+		return w
+	}
+	for start, n := range sent {
+		window(start).Sent += n
+	}
+	for start, n := range received {
+		window(start).Received += n
+	}
+
+	var losses []float64
+	for _, w := range windows {
+		w.Loss = loss(w.Sent, w.Received)
+		r.Sent += w.Sent
+		r.Received += w.Received
+		r.Windows = append(r.Windows, *w)
+
+		if w.Sent > 0 {
+			losses = append(losses, w.Loss)
+		}
+	}
+	sort.Slice(r.Windows, func(i, j int) bool {
+		return r.Windows[i].Start < r.Windows[j].Start
+	})
+	r.Loss = loss(r.Sent, r.Received)
+
+	for i, w := range r.Windows {
+		if w.Sent > 0 && (r.Worst == nil || w.Loss > r.Worst.Loss) {
+			r.Worst = &r.Windows[i]
+		}
+	}
+
+	if len(losses) > 0 {
+		sort.Float64s(losses)
+		r.Percentiles = map[string]float64{
+			"p50": percentile(losses, 50),
+			"p90": percentile(losses, 90),
+			"p99": percentile(losses, 99),
+			"max": losses[len(losses)-1],
+		}
+	}
+
+	return r
+}
+
+// WriteText writes the reports as a table for reading in a terminal.
+func WriteText(w io.Writer, reports []Report) error {
+	for _, r := range reports {
+		eventType := r.EventType
+		if eventType == "" {
+			eventType = noTag
+		}
+
+		fmt.Fprintf(w, "event_type: %s\n", eventType)
+		if r.Group != "" {
+			fmt.Fprintf(w, "  group: %s\n", r.Group)
+		}
+		if r.Egress != "" {
+			fmt.Fprintf(w, "  egress: %s\n", r.Egress)
+		}
+		fmt.Fprintf(w, "  sent: %d received: %d loss: %.4f%%\n", r.Sent, r.Received, r.Loss)
+		if r.Worst != nil {
+			fmt.Fprintf(w, "  worst window: %s loss: %.4f%%\n", formatTime(r.Worst.Start), r.Worst.Loss)
+			fmt.Fprintf(w, "  loss p50: %.4f%% p90: %.4f%% p99: %.4f%% max: %.4f%%\n",
+				r.Percentiles["p50"],
+				r.Percentiles["p90"],
+				r.Percentiles["p99"],
+				r.Percentiles["max"],
+			)
+		}
+
+		fmt.Fprintf(w, "  %-20s %12s %12s %10s\n", "window", "sent", "received", "loss")
+		for _, win := range r.Windows {
+			fmt.Fprintf(w, "  %-20s %12d %12d %9.4f%%\n", formatTime(win.Start), win.Sent, win.Received, win.Loss)
+		}
+
+		_, err := fmt.Fprintln(w)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// WriteJSON writes the reports as a JSON document.
+func WriteJSON(w io.Writer, reports []Report) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(map[string][]Report{"reports": reports})
+}
+
+func loss(sent, received int64) float64 {
+	if sent == 0 {
+		return 0
+	}
+
+	return float64(sent-received) / float64(sent) * 100
+}
+
+// percentile returns the nearest-rank percentile of sorted values.
+func percentile(sorted []float64, p int) float64 {
+	rank := int(math.Ceil(float64(p) / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+
+	return sorted[rank-1]
+}
+
+// noTag is the value reported for a tag that a point does not have.
+const noTag = "(none)"
+
+func tagValue(tags []string, key string) string {
+	v, _ := lookupTag(tags, key)
+	return v
+}
+
+func lookupTag(tags []string, key string) (string, bool) {
+	for _, t := range tags {
+		if strings.HasPrefix(t, key+":") {
+			return strings.TrimPrefix(t, key+":"), true
+		}
+	}
+
+	return "", false
+}
+
+func formatTime(ts int64) string {
+	return time.Unix(ts, 0).UTC().Format("2006-01-02T15:04:05Z")
+}
@@ -0,0 +1,188 @@
+package report_test
+
+import (
+	"bytes"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/datadogreporter"
+	"code.cloudfoundry.org/loss_report/internal/report"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Builder", func() {
+	newBuilder := func(groupBy ...string) *report.Builder {
+		return report.NewBuilder(
+			"capacity_planning.sent",
+			"capacity_planning.received",
+			time.Minute,
+			report.WithGroupBy(groupBy...),
+		)
+	}
+
+	sent := func(points [][]int64, tags ...string) datadogreporter.Point {
+		return datadogreporter.Point{
+			Metric: "capacity_planning.sent",
+			Points: points,
+			Tags:   tags,
+		}
+	}
+
+	received := func(points [][]int64, tags ...string) datadogreporter.Point {
+		return datadogreporter.Point{
+			Metric: "capacity_planning.received",
+			Points: points,
+			Tags:   tags,
+		}
+	}
+
+	It("matches sent and received counts in each window", func() {
+		b := newBuilder()
+		b.AddPoint(sent([][]int64{{0, 100}, {59, 100}, {60, 200}}, "event_type:logs"))
+		b.AddPoint(received([][]int64{{1, 150}, {61, 200}}, "event_type:logs"))
+		b.AddPoint(datadogreporter.Point{Metric: "capacity_planning.lost", Points: [][]int64{{0, 50}}})
+
+		reports := b.Reports()
+		Expect(reports).To(HaveLen(1))
+
+		r := reports[0]
+		Expect(r.EventType).To(Equal("logs"))
+		Expect(r.Sent).To(Equal(int64(400)))
+		Expect(r.Received).To(Equal(int64(350)))
+		Expect(r.Loss).To(Equal(12.5))
+		Expect(r.Windows).To(Equal([]report.Window{
+			{Start: 0, Sent: 200, Received: 150, Loss: 25},
+			{Start: 60, Sent: 200, Received: 200, Loss: 0},
+		}))
+		Expect(r.Worst).To(Equal(&report.Window{Start: 0, Sent: 200, Received: 150, Loss: 25}))
+		Expect(r.Percentiles).To(Equal(map[string]float64{
+			"p50": 0,
+			"p90": 25,
+			"p99": 25,
+			"max": 25,
+		}))
+	})
+
+	It("reports event types separately", func() {
+		b := newBuilder()
+		b.AddPoint(sent([][]int64{{0, 100}}, "event_type:logs"))
+		b.AddPoint(sent([][]int64{{0, 10}}, "event_type:metrics"))
+		b.AddPoint(received([][]int64{{0, 90}}, "event_type:logs"))
+		b.AddPoint(received([][]int64{{0, 10}}, "event_type:metrics"))
+
+		reports := b.Reports()
+		Expect(reports).To(HaveLen(2))
+		Expect(reports[0].EventType).To(Equal("logs"))
+		Expect(reports[0].Loss).To(Equal(10.0))
+		Expect(reports[1].EventType).To(Equal("metrics"))
+		Expect(reports[1].Loss).To(Equal(0.0))
+	})
+
+	It("groups by tags", func() {
+		b := newBuilder("source_instance")
+		b.AddPoint(sent([][]int64{{0, 100}}, "event_type:logs", "source_instance:0"))
+		b.AddPoint(sent([][]int64{{0, 100}}, "event_type:logs", "source_instance:1"))
+		b.AddPoint(received([][]int64{{0, 100}}, "event_type:logs", "source_instance:0"))
+		b.AddPoint(received([][]int64{{0, 50}}, "event_type:logs", "source_instance:1"))
+
+		reports := b.Reports()
+		Expect(reports).To(HaveLen(2))
+		Expect(reports[0].Group).To(Equal("source_instance:0"))
+		Expect(reports[0].Loss).To(Equal(0.0))
+		Expect(reports[1].Group).To(Equal("source_instance:1"))
+		Expect(reports[1].Loss).To(Equal(50.0))
+	})
+
+	It("keeps points without a grouped tag apart from those with it", func() {
+		b := newBuilder("source_instance")
+		b.AddPoint(sent([][]int64{{0, 100}}, "event_type:logs", "source_instance:0"))
+		b.AddPoint(sent([][]int64{{0, 100}}, "event_type:logs"))
+		b.AddPoint(received([][]int64{{0, 100}}, "event_type:logs", "source_instance:0"))
+		b.AddPoint(received([][]int64{{0, 100}}, "event_type:logs", "source_instance:"))
+
+		reports := b.Reports()
+		Expect(reports).To(HaveLen(3))
+		Expect(reports[0].Group).To(Equal("source_instance:"))
+		Expect(reports[0].Sent).To(Equal(int64(0)))
+		Expect(reports[0].Received).To(Equal(int64(100)))
+		Expect(reports[1].Group).To(Equal("source_instance:(none)"))
+		Expect(reports[1].Sent).To(Equal(int64(100)))
+		Expect(reports[1].Received).To(Equal(int64(0)))
+		Expect(reports[2].Group).To(Equal("source_instance:0"))
+		Expect(reports[2].Loss).To(Equal(0.0))
+	})
+
+	It("compares sent events with the events received through each egress", func() {
+		b := newBuilder("egress")
+		b.AddPoint(sent([][]int64{{0, 100}}, "event_type:logs"))
+		b.AddPoint(received([][]int64{{0, 100}}, "event_type:logs", "egress:v1"))
+		b.AddPoint(received([][]int64{{0, 80}}, "event_type:logs", "egress:rlp"))
+
+		reports := b.Reports()
+		Expect(reports).To(HaveLen(2))
+		Expect(reports[0].Egress).To(Equal("rlp"))
+		Expect(reports[0].Sent).To(Equal(int64(100)))
+		Expect(reports[0].Loss).To(Equal(20.0))
+		Expect(reports[1].Egress).To(Equal("v1"))
+		Expect(reports[1].Sent).To(Equal(int64(100)))
+		Expect(reports[1].Loss).To(Equal(0.0))
+	})
+
+	It("reports sent events that were never received", func() {
+		b := newBuilder()
+		b.AddPoint(sent([][]int64{{0, 100}}, "event_type:logs"))
+
+		reports := b.Reports()
+		Expect(reports).To(HaveLen(1))
+		Expect(reports[0].Received).To(Equal(int64(0)))
+		Expect(reports[0].Loss).To(Equal(100.0))
+	})
+
+	It("does not count windows without sent events as loss", func() {
+		b := newBuilder()
+		b.AddPoint(sent([][]int64{{0, 0}, {60, 100}}, "event_type:logs"))
+		b.AddPoint(received([][]int64{{0, 5}, {60, 100}, {120, 3}}, "event_type:logs"))
+
+		r := b.Reports()[0]
+		Expect(r.Windows).To(Equal([]report.Window{
+			{Start: 0, Sent: 0, Received: 5, Loss: 0},
+			{Start: 60, Sent: 100, Received: 100, Loss: 0},
+			{Start: 120, Sent: 0, Received: 3, Loss: 0},
+		}))
+		Expect(r.Worst).To(Equal(&report.Window{Start: 60, Sent: 100, Received: 100}))
+		Expect(r.Percentiles).To(HaveLen(4))
+	})
+
+	It("has no worst window or percentiles when nothing was sent", func() {
+		b := newBuilder()
+		b.AddPoint(received([][]int64{{0, 5}}, "event_type:logs"))
+
+		r := b.Reports()[0]
+		Expect(r.Sent).To(Equal(int64(0)))
+		Expect(r.Loss).To(Equal(0.0))
+		Expect(r.Worst).To(BeNil())
+		Expect(r.Percentiles).To(BeNil())
+
+		var buf bytes.Buffer
+		Expect(report.WriteText(&buf, b.Reports())).To(Succeed())
+		Expect(buf.String()).ToNot(ContainSubstring("worst window"))
+	})
+
+	It("reads points written by the JSON sinks and skips other lines", func() {
+		b := newBuilder()
+		err := b.Add(strings.NewReader(
+			`{"metric":"capacity_planning.sent","points":[[0,10]],"type":"gauge","tags":["event_type:logs"]}` + "\n" +
+				"\n" +
+				"2026/01/01 00:00:00 not a point\n" +
+				`{"metric":"capacity_planning.received","points":[[0,9],[1]],"type":"gauge","tags":["event_type:logs"]}` + "\n",
+		))
+		Expect(err).ToNot(HaveOccurred())
+
+		reports := b.Reports()
+		Expect(reports).To(HaveLen(1))
+		Expect(reports[0].Sent).To(Equal(int64(10)))
+		Expect(reports[0].Received).To(Equal(int64(9)))
+	})
+})
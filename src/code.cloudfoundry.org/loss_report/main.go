@@ -0,0 +1,67 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/loss_report/internal/report"
+)
+
+func main() {
+	sentMetric := flag.String("sent-metric", "capacity_planning.sent", "Name of the series counting sent events.")
+	receivedMetric := flag.String("received-metric", "capacity_planning.received", "Name of the series counting received events.")
+	window := flag.Duration("window", time.Minute, "Length of the windows that loss is computed over.")
+	format := flag.String("format", "text", "Output format: text or json.")
+	groupBy := flag.String("group-by", "", "Comma separated tags to report loss for separately within each event type, e.g. source_instance,cell. Loss is always reported per egress.")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] <file or directory>...\n\n", os.Args[0])
+		fmt.Fprintln(os.Stderr, "Reads points written by the stdout or file sinks and reports loss per event type.")
+		fmt.Fprintln(os.Stderr, "Points are read from stdin when no paths are given.")
+		fmt.Fprintln(os.Stderr)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *format != "text" && *format != "json" {
+		log.Fatalf("Invalid format %q, must be text or json", *format)
+	}
+
+	var groupKeys []string
+	if *groupBy != "" {
+		groupKeys = strings.Split(*groupBy, ",")
+	}
+
+	b := report.NewBuilder(*sentMetric, *receivedMetric, *window, report.WithGroupBy(groupKeys...))
+	if flag.NArg() == 0 {
+		err := b.Add(os.Stdin)
+		if err != nil {
+			log.Fatalf("failed to read points: %s", err)
+		}
+	}
+
+	for _, path := range flag.Args() {
+		err := b.AddPath(path)
+		if err != nil {
+			log.Fatalf("failed to read points: %s", err)
+		}
+	}
+
+	reports := b.Reports()
+	if len(reports) == 0 {
+		log.Fatalf("No %s or %s points found", *sentMetric, *receivedMetric)
+	}
+
+	var err error
+	if *format == "json" {
+		err = report.WriteJSON(os.Stdout, reports)
+	} else {
+		err = report.WriteText(os.Stdout, reports)
+	}
+	if err != nil {
+		log.Fatalf("failed to write report: %s", err)
+	}
+}
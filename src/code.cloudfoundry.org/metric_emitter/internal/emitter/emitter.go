@@ -1,16 +1,17 @@
 package emitter
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"sync/atomic"
-	"time"
 
 	"github.com/cloudfoundry/dropsonde"
 
 	"code.cloudfoundry.org/datadogreporter"
 	loggregator "code.cloudfoundry.org/go-loggregator"
 	"code.cloudfoundry.org/go-loggregator/v1"
+	"code.cloudfoundry.org/pacer"
 )
 
 type Client interface {
@@ -18,10 +19,11 @@ type Client interface {
 }
 
 type Emitter struct {
-	client           Client
-	metricsPerSecond uint
-	sentCount        int64
-	apiVersion       string
+	sent       int64
+	client     Client
+	pacer      *pacer.Pacer
+	sentCount  datadogreporter.Counter
+	apiVersion string
 }
 
 func New(
@@ -30,7 +32,7 @@ func New(
 	keyPath string,
 	apiVersion string,
 	origin string,
-	metricsPerSecond uint,
+	metricsPerSecond float64,
 ) *Emitter {
 	var client Client
 	var err error
@@ -58,42 +60,53 @@ func New(
 	}
 
 	return &Emitter{
-		client:           client,
-		metricsPerSecond: metricsPerSecond,
-		apiVersion:       apiVersion,
+		client:     client,
+		pacer:      pacer.New(metricsPerSecond),
+		apiVersion: apiVersion,
 	}
 }
 
-func (e *Emitter) Run() {
-	ns := time.Second / time.Duration(e.metricsPerSecond)
+// SetRate changes the number of metrics emitted per second.
+func (e *Emitter) SetRate(metricsPerSecond float64) {
+	e.pacer.SetRate(metricsPerSecond)
+}
 
+func (e *Emitter) Run(ctx context.Context) {
 	var metricNames []string
 	for i := 0; i < 100; i++ {
 		metricNames = append(metricNames, fmt.Sprintf("capacity-planning-metric-%d", i))
 	}
 
-	ticker := time.NewTicker(ns)
-	for range ticker.C {
-		idx := atomic.LoadInt64(&e.sentCount) % int64(len(metricNames))
-		e.client.EmitCounter(metricNames[idx])
-		atomic.AddInt64(&e.sentCount, 1)
-	}
+	var i int
+	e.pacer.Run(ctx, func(n int) {
+		for j := 0; j < n; j++ {
+			e.client.EmitCounter(metricNames[i%len(metricNames)])
+			i++
+		}
+		atomic.AddInt64(&e.sent, int64(n))
+		e.sentCount.Add(int64(n))
+	})
 }
 
-func (e *Emitter) BuildPoints() []datadogreporter.Point {
-	sent := atomic.SwapInt64(&e.sentCount, 0)
+// Sent returns the total number of metrics emitted.
+func (e *Emitter) Sent() int64 {
+	return atomic.LoadInt64(&e.sent)
+}
 
-	return []datadogreporter.Point{
+func (e *Emitter) BuildPoints(w datadogreporter.Window) []datadogreporter.Point {
+	tags := []string{
+		"event_type:metrics",
+		"api_version:" + e.apiVersion,
+	}
+
+	points := []datadogreporter.Point{
 		{
 			Metric: "capacity_planning.sent",
-			Points: [][]int64{
-				[]int64{time.Now().Unix(), sent},
-			},
-			Type: "gauge",
-			Tags: []string{
-				"event_type:metrics",
-				"api_version:" + e.apiVersion,
-			},
+			Points: e.sentCount.Samples(w),
+			Type:   "gauge",
+			Tags:   tags,
 		},
 	}
+
+	return append(points, datadogreporter.RatePoints("capacity_planning.target_rate", w, e.pacer.Rate(), tags)...)
 }
@@ -8,8 +8,11 @@ import (
 	"strings"
 	"time"
 
+	"code.cloudfoundry.org/control"
 	"code.cloudfoundry.org/datadogreporter"
+	"code.cloudfoundry.org/loadprofile"
 	"code.cloudfoundry.org/metric_emitter/internal/emitter"
+	"code.cloudfoundry.org/shutdown"
 )
 
 var (
@@ -28,10 +31,21 @@ var (
 func main() {
 	apiVersion := flag.String("api-version", "", "Version of API to write metrics to. (v1 or v2)")
 	origin := flag.String("origin", "", "Origin to be applied to all outgoing envelopes")
-	metricsPerSecond := flag.Uint("metrics-per-second", 1000, "Number of counter events to be emitted per second")
+	metricsPerSecond := flag.Float64("metrics-per-second", 1000, "Number of counter events to be emitted per second")
+	loadProfile := flag.String("load-profile", "", "Profile that varies metrics-per-second over time, e.g. ramp:100,10000,30m.")
 	datadogAPIKey := flag.String("datadog-api-key", "", "Datadog API key.")
+	sinks := flag.String("sink", "datadog", "Comma separated list of sinks for metrics: datadog, statsd:<addr>, stdout, file:<path> or prometheus:<addr>.")
+	spoolPath := flag.String("datadog-spool-path", "", "File for holding Datadog series that have not been submitted.")
+	datadogSite := flag.String("datadog-site", "", "Datadog site to send metrics to, e.g. datadoghq.eu. Defaults to the US1 site.")
+	datadogAPIVersion := flag.String("datadog-api-version", "v1", "Version of the Datadog series API: v1 or v2.")
+	datadogCompress := flag.Bool("datadog-compress", false, "Gzip requests to Datadog.")
+	drainTimeout := flag.Duration("drain-timeout", 5*time.Second, "Time to keep counting after emitters stop on shutdown.")
+	resolution := flag.String("report-resolution", "interval", "How per-second counts are reported: interval, second or summary.")
 	jobName := flag.String("job-name", "", "Name of the bosh job")
 	instanceID := flag.String("instance-id", "", "Bosh job instance ID")
+	controlAddr := flag.String("control-addr", "", "Address to serve the control API on, for changing the rate at runtime. Empty disables it. Only loopback addresses are served without a token.")
+	controlToken := flag.String("control-token", "", "Bearer token required to change anything through the control API.")
+	controlMaxRate := flag.Float64("control-max-rate", 100000, "Highest metrics-per-second that can be set through the control API. 0 is unbounded.")
 
 	caPath := flag.String("ca-path", "", "Path to certificate authority cert")
 	certPath := flag.String("cert-path", "", "Path to client certificate for connecting to metron")
@@ -43,10 +57,6 @@ func main() {
 		missing = append(missing, "origin")
 	}
 
-	if *datadogAPIKey == "" {
-		missing = append(missing, "datadog-api-key")
-	}
-
 	if *jobName == "" {
 		missing = append(missing, "job-name")
 	}
@@ -59,6 +69,26 @@ func main() {
 		log.Fatalf("missing required flags: %s", strings.Join(missing, ", "))
 	}
 
+	sink, err := datadogreporter.NewSink(datadogreporter.SinkConfig{
+		Sinks:      *sinks,
+		APIKey:     *datadogAPIKey,
+		SpoolPath:  *spoolPath,
+		Site:       *datadogSite,
+		APIVersion: *datadogAPIVersion,
+		Compress:   *datadogCompress,
+	})
+	if err != nil {
+		log.Fatalf("failed to create metrics sink: %s", err)
+	}
+
+	var profile loadprofile.Profile
+	if *loadProfile != "" {
+		profile, err = loadprofile.Parse(*loadProfile)
+		if err != nil {
+			log.Fatalf("invalid load profile: %s", err)
+		}
+	}
+
 	emitter := emitter.New(
 		*caPath,
 		*certPath,
@@ -67,13 +97,33 @@ func main() {
 		*origin,
 		*metricsPerSecond,
 	)
-	go emitter.Run()
+	emitCtx, countCtx := shutdown.Contexts(*drainTimeout)
+	go emitter.Run(emitCtx)
+
+	controller := control.New(emitter, *metricsPerSecond,
+		control.WithToken(*controlToken),
+		control.WithMaxRate(*controlMaxRate),
+		control.WithCounts(func() map[string]int64 {
+			return map[string]int64{"sent": emitter.Sent()}
+		}),
+	)
+	if *controlAddr != "" {
+		err := controller.ListenAndServe(*controlAddr)
+		if err != nil {
+			log.Fatalf("failed to serve the control API: %s", err)
+		}
+	}
+	if profile != nil {
+		go loadprofile.Run(emitCtx, profile, controller, 100*time.Millisecond)
+	}
 
 	reporter := datadogreporter.New(
 		*datadogAPIKey,
 		*jobName,
 		*instanceID,
 		emitter,
+		datadogreporter.WithSink(sink),
+		datadogreporter.WithResolution(*resolution),
 	)
-	reporter.Run()
+	reporter.Run(countCtx)
 }
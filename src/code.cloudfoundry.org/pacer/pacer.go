@@ -0,0 +1,120 @@
+// Package pacer emits events at a target rate. Events are emitted in
+// batches on every tick, sized from the time that actually elapsed, so that
+// the target is met at high rates despite timer granularity and the cost of
+// emitting.
+package pacer
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	defaultTick       = 10 * time.Millisecond
+	defaultMaxBacklog = time.Second
+)
+
+// Pacer calls an emit function with the number of events that are due to
+// keep up with its rate. Fractions of an event carry over between ticks, so
+// rates below one per second are supported. A rate of zero pauses emission.
+type Pacer struct {
+	tick       time.Duration
+	maxBacklog time.Duration
+
+	mu   sync.Mutex
+	rate float64
+}
+
+func New(rate float64, opts ...pacerOpt) *Pacer {
+	p := &Pacer{
+		tick:       defaultTick,
+		maxBacklog: defaultMaxBacklog,
+	}
+	p.SetRate(rate)
+
+	for _, o := range opts {
+		o(p)
+	}
+
+	return p
+}
+
+// Rate returns the target rate in events per second.
+func (p *Pacer) Rate() float64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.rate
+}
+
+// SetRate changes the target rate. Negative rates are treated as zero.
+func (p *Pacer) SetRate(rate float64) {
+	if rate < 0 || math.IsNaN(rate) {
+		rate = 0
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.rate = rate
+}
+
+// Run calls emit on every tick with the number of events that are due,
+// until the context is done. When emit falls behind, the deficit is made up
+// in later batches, up to the max backlog.
+func (p *Pacer) Run(ctx context.Context, emit func(n int)) {
+	t := time.NewTicker(p.tick)
+	defer t.Stop()
+
+	last := time.Now()
+	var owed float64
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+
+		now := time.Now()
+		elapsed := now.Sub(last)
+		last = now
+
+		rate := p.Rate()
+		if rate == 0 {
+			owed = 0
+			continue
+		}
+
+		owed += rate * elapsed.Seconds()
+		if max := math.Max(rate*p.maxBacklog.Seconds(), 1); owed > max {
+			owed = max
+		}
+
+		n := int(owed)
+		if n > 0 {
+			owed -= float64(n)
+			emit(n)
+		}
+	}
+}
+
+type pacerOpt func(*Pacer)
+
+// WithTick sets how often batches are emitted.
+func WithTick(d time.Duration) pacerOpt {
+	return func(p *Pacer) {
+		if d > 0 {
+			p.tick = d
+		}
+	}
+}
+
+// WithMaxBacklog bounds how far behind the target the pacer will try to
+// catch up, as a duration at the target rate.
+func WithMaxBacklog(d time.Duration) pacerOpt {
+	return func(p *Pacer) {
+		p.maxBacklog = d
+	}
+}
@@ -0,0 +1,13 @@
+package pacer_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestPacer(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Pacer Suite")
+}
@@ -0,0 +1,90 @@
+package pacer_test
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"code.cloudfoundry.org/pacer"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Pacer", func() {
+	run := func(p *pacer.Pacer, d time.Duration, emit func(int)) int64 {
+		var total int64
+		ctx, cancel := context.WithTimeout(context.Background(), d)
+		defer cancel()
+
+		p.Run(ctx, func(n int) {
+			atomic.AddInt64(&total, int64(n))
+			if emit != nil {
+				emit(n)
+			}
+		})
+
+		return atomic.LoadInt64(&total)
+	}
+
+	It("emits batches at the target rate", func() {
+		var batches int64
+		p := pacer.New(50000)
+
+		total := run(p, 500*time.Millisecond, func(int) {
+			atomic.AddInt64(&batches, 1)
+		})
+
+		Expect(total).To(BeNumerically("~", 25000, 2500))
+		Expect(atomic.LoadInt64(&batches)).To(BeNumerically("<", total/10))
+	})
+
+	It("makes up for slow emits", func() {
+		p := pacer.New(1000, pacer.WithTick(time.Millisecond))
+
+		total := run(p, 500*time.Millisecond, func(int) {
+			time.Sleep(20 * time.Millisecond)
+		})
+
+		Expect(total).To(BeNumerically("~", 500, 60))
+	})
+
+	It("supports rates below one per second", func() {
+		var at []time.Duration
+		start := time.Now()
+		p := pacer.New(0.5)
+
+		total := run(p, 4500*time.Millisecond, func(int) {
+			at = append(at, time.Since(start))
+		})
+
+		Expect(total).To(Equal(int64(2)))
+		Expect(at).To(HaveLen(2))
+		Expect(at[0]).To(BeNumerically("~", 2*time.Second, 200*time.Millisecond))
+		Expect(at[1]).To(BeNumerically("~", 4*time.Second, 200*time.Millisecond))
+	})
+
+	It("pauses at a rate of zero", func() {
+		p := pacer.New(0)
+
+		Expect(run(p, 100*time.Millisecond, nil)).To(BeZero())
+	})
+
+	It("treats negative rates as zero", func() {
+		p := pacer.New(-1)
+
+		Expect(p.Rate()).To(BeZero())
+	})
+
+	It("follows rate changes", func() {
+		p := pacer.New(0)
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			p.SetRate(1000)
+		}()
+
+		total := run(p, 300*time.Millisecond, nil)
+
+		Expect(total).To(BeNumerically("~", 200, 40))
+	})
+})
@@ -0,0 +1,240 @@
+// Package ratesearch finds the highest rate an emitter can sustain without
+// losing more than a threshold of its events. The rate is doubled until a
+// step fails, then the last passing and first failing rates are bisected
+// until they are within the configured precision.
+package ratesearch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Rater is anything with a target rate that can be changed.
+type Rater interface {
+	SetRate(float64)
+}
+
+// Counts returns the total number of events sent and received so far.
+type Counts func() (sent, received int64)
+
+// Config controls the search.
+type Config struct {
+	// MinRate is the first rate tried.
+	MinRate float64
+
+	// MaxRate bounds the search. Zero leaves it unbounded.
+	MaxRate float64
+
+	// LossThreshold is the highest loss, as a percentage, that a step may
+	// have and still pass.
+	LossThreshold float64
+
+	// Soak is how long each rate is held while counting.
+	Soak time.Duration
+
+	// Settle is how long each rate is held before counting starts, so that
+	// events in flight from the previous rate are not counted against it.
+	Settle time.Duration
+
+	// Precision ends the search once the gap between the passing and
+	// failing rates is at most this fraction of the failing rate.
+	Precision float64
+
+	// MaxSteps bounds the number of rates tried. Zero uses a default of 50.
+	MaxSteps int
+}
+
+const defaultMaxSteps = 50
+
+// ErrMaxSteps is returned when the search runs out of steps before
+// reaching its precision.
+var ErrMaxSteps = errors.New("search reached its maximum number of steps")
+
+// Step is the outcome of holding a single rate.
+type Step struct {
+	Rate     float64 `json:"rate"`
+	Sent     int64   `json:"sent"`
+	Received int64   `json:"received"`
+	Loss     float64 `json:"loss_percent"`
+	Passed   bool    `json:"passed"`
+}
+
+// Result is the highest passing rate and every step taken to find it. Rate
+// is zero if no rate passed.
+type Result struct {
+	Rate  float64 `json:"rate"`
+	Steps []Step  `json:"steps"`
+}
+
+// Searcher runs a search against a rater, measuring loss with counts.
+type Searcher struct {
+	cfg    Config
+	rater  Rater
+	counts Counts
+	onStep func(Step)
+}
+
+func New(cfg Config, r Rater, c Counts, opts ...searcherOpt) (*Searcher, error) {
+	if cfg.MinRate <= 0 {
+		return nil, errors.New("min rate must be positive")
+	}
+	if cfg.MaxRate != 0 && cfg.MaxRate < cfg.MinRate {
+		return nil, fmt.Errorf("max rate %g is below min rate %g", cfg.MaxRate, cfg.MinRate)
+	}
+	if cfg.LossThreshold < 0 {
+		return nil, errors.New("loss threshold must not be negative")
+	}
+	if cfg.Soak <= 0 {
+		return nil, errors.New("soak must be positive")
+	}
+	if cfg.Precision <= 0 || cfg.Precision >= 1 {
+		return nil, errors.New("precision must be between 0 and 1")
+	}
+	if cfg.MaxSteps < 0 {
+		return nil, errors.New("max steps must not be negative")
+	}
+	if cfg.MaxSteps == 0 {
+		cfg.MaxSteps = defaultMaxSteps
+	}
+
+	s := &Searcher{
+		cfg:    cfg,
+		rater:  r,
+		counts: c,
+		onStep: func(Step) {},
+	}
+
+	for _, o := range opts {
+		o(s)
+	}
+
+	return s, nil
+}
+
+type searcherOpt func(*Searcher)
+
+// WithStepHandler sets a function that is called after every step, e.g. to
+// log progress.
+func WithStepHandler(f func(Step)) searcherOpt {
+	return func(s *Searcher) {
+		s.onStep = f
+	}
+}
+
+// Run searches until the precision is reached or the context is done. On
+// return the rater is left at the result's rate. If the min rate fails the
+// search stops with a rate of zero. If the context is done or the steps run
+// out first, the best rate found so far is returned with an error.
+func (s *Searcher) Run(ctx context.Context) (Result, error) {
+	var (
+		result Result
+		lo     float64
+		hi     float64
+	)
+	defer func() {
+		s.rater.SetRate(result.Rate)
+	}()
+
+	// Grow until a step fails or the max rate passes.
+	rate := s.cfg.MinRate
+	for {
+		if len(result.Steps) >= s.cfg.MaxSteps {
+			return result, ErrMaxSteps
+		}
+
+		step, err := s.step(ctx, rate)
+		if err != nil {
+			return result, err
+		}
+		result.Steps = append(result.Steps, step)
+
+		if !step.Passed {
+			if lo == 0 {
+				return result, nil
+			}
+			hi = rate
+			break
+		}
+
+		lo = rate
+		result.Rate = rate
+		if s.cfg.MaxRate != 0 && rate >= s.cfg.MaxRate {
+			return result, nil
+		}
+
+		rate *= 2
+		if s.cfg.MaxRate != 0 && rate > s.cfg.MaxRate {
+			rate = s.cfg.MaxRate
+		}
+	}
+
+	// Bisect between the last passing and first failing rates.
+	for hi-lo > s.cfg.Precision*hi {
+		if len(result.Steps) >= s.cfg.MaxSteps {
+			return result, ErrMaxSteps
+		}
+
+		rate := (lo + hi) / 2
+		step, err := s.step(ctx, rate)
+		if err != nil {
+			return result, err
+		}
+		result.Steps = append(result.Steps, step)
+
+		if step.Passed {
+			lo = rate
+			result.Rate = rate
+		} else {
+			hi = rate
+		}
+	}
+
+	return result, nil
+}
+
+// step holds a rate and measures its loss. Counting starts after the
+// settle time, so at a steady rate the events that are still in flight at
+// the end of the soak are balanced by those in flight at its start.
+func (s *Searcher) step(ctx context.Context, rate float64) (Step, error) {
+	s.rater.SetRate(rate)
+	if !sleep(ctx, s.cfg.Settle) {
+		return Step{}, ctx.Err()
+	}
+
+	sent0, received0 := s.counts()
+	if !sleep(ctx, s.cfg.Soak) {
+		return Step{}, ctx.Err()
+	}
+	sent1, received1 := s.counts()
+
+	step := Step{
+		Rate:     rate,
+		Sent:     sent1 - sent0,
+		Received: received1 - received0,
+	}
+	if step.Sent > 0 {
+		step.Loss = float64(step.Sent-step.Received) / float64(step.Sent) * 100
+	}
+	step.Passed = step.Sent > 0 && step.Loss <= s.cfg.LossThreshold
+	s.onStep(step)
+
+	return step, nil
+}
+
+func sleep(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
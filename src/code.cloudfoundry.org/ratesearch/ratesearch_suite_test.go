@@ -0,0 +1,13 @@
+package ratesearch_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestRatesearch(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Ratesearch Suite")
+}
@@ -0,0 +1,198 @@
+package ratesearch_test
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/ratesearch"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Searcher", func() {
+	var (
+		system *fakeSystem
+		cfg    ratesearch.Config
+	)
+
+	BeforeEach(func() {
+		system = &fakeSystem{capacity: 1000}
+		cfg = ratesearch.Config{
+			MinRate:       100,
+			LossThreshold: 1,
+			Soak:          time.Millisecond,
+			Precision:     0.05,
+		}
+	})
+
+	It("finds the highest rate with loss under the threshold", func() {
+		s, err := ratesearch.New(cfg, system, system.counts)
+		Expect(err).ToNot(HaveOccurred())
+
+		result, err := s.Run(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(result.Rate).To(BeNumerically("<=", 1010))
+		Expect(result.Rate).To(BeNumerically(">=", 1010*0.95))
+		Expect(system.rate()).To(Equal(result.Rate))
+
+		rates := make([]float64, 0, 5)
+		for _, step := range result.Steps[:5] {
+			rates = append(rates, step.Rate)
+		}
+		Expect(rates).To(Equal([]float64{100, 200, 400, 800, 1600}))
+		Expect(result.Steps[4].Passed).To(BeFalse())
+		Expect(result.Steps[4].Loss).To(BeNumerically("~", 37.5, 0.01))
+	})
+
+	It("stops at the max rate", func() {
+		cfg.MaxRate = 500
+		s, err := ratesearch.New(cfg, system, system.counts)
+		Expect(err).ToNot(HaveOccurred())
+
+		result, err := s.Run(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(result.Rate).To(Equal(500.0))
+		Expect(result.Steps).To(HaveLen(4))
+		Expect(result.Steps[3].Rate).To(Equal(500.0))
+	})
+
+	It("stops with a rate of zero when the min rate fails", func() {
+		system.capacity = 30
+		s, err := ratesearch.New(cfg, system, system.counts)
+		Expect(err).ToNot(HaveOccurred())
+
+		result, err := s.Run(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(result.Rate).To(Equal(0.0))
+		Expect(result.Steps).To(HaveLen(1))
+		Expect(system.probes).To(Equal(1))
+		Expect(system.rate()).To(Equal(0.0))
+	})
+
+	It("takes a bounded number of steps", func() {
+		s, err := ratesearch.New(cfg, system, system.counts)
+		Expect(err).ToNot(HaveOccurred())
+
+		result, err := s.Run(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+
+		// 5 steps to find 800-1600, then 4 to narrow it to 1000-1050.
+		Expect(result.Steps).To(HaveLen(9))
+		Expect(system.probes).To(Equal(9))
+	})
+
+	It("stops at the max steps", func() {
+		cfg.MaxSteps = 6
+		s, err := ratesearch.New(cfg, system, system.counts)
+		Expect(err).ToNot(HaveOccurred())
+
+		result, err := s.Run(context.Background())
+		Expect(err).To(Equal(ratesearch.ErrMaxSteps))
+
+		Expect(result.Steps).To(HaveLen(6))
+		Expect(result.Rate).To(Equal(800.0))
+		Expect(system.rate()).To(Equal(800.0))
+	})
+
+	It("reports every step", func() {
+		var steps []ratesearch.Step
+		s, err := ratesearch.New(cfg, system, system.counts, ratesearch.WithStepHandler(func(s ratesearch.Step) {
+			steps = append(steps, s)
+		}))
+		Expect(err).ToNot(HaveOccurred())
+
+		result, err := s.Run(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(steps).To(Equal(result.Steps))
+	})
+
+	It("returns the best rate so far when the context is done", func() {
+		cfg.Soak = 100 * time.Millisecond
+		s, err := ratesearch.New(cfg, system, system.counts)
+		Expect(err).ToNot(HaveOccurred())
+
+		ctx, cancel := context.WithTimeout(context.Background(), 250*time.Millisecond)
+		defer cancel()
+
+		result, err := s.Run(ctx)
+		Expect(err).To(Equal(context.DeadlineExceeded))
+		Expect(result.Rate).To(Equal(200.0))
+		Expect(system.rate()).To(Equal(200.0))
+	})
+
+	It("fails steps that send nothing", func() {
+		system.capacity = 0
+		cfg.MinRate = 0.5
+		s, err := ratesearch.New(cfg, system, func() (int64, int64) { return 0, 0 })
+		Expect(err).ToNot(HaveOccurred())
+
+		result, err := s.Run(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.Rate).To(Equal(0.0))
+		Expect(result.Steps).To(HaveLen(1))
+	})
+
+	It("validates the config", func() {
+		for _, c := range []ratesearch.Config{
+			{MinRate: 0, Soak: time.Second, Precision: 0.1},
+			{MinRate: 10, MaxRate: 5, Soak: time.Second, Precision: 0.1},
+			{MinRate: 10, LossThreshold: -1, Soak: time.Second, Precision: 0.1},
+			{MinRate: 10, Soak: 0, Precision: 0.1},
+			{MinRate: 10, Soak: time.Second, Precision: 0},
+			{MinRate: 10, Soak: time.Second, Precision: 0.1, MaxSteps: -1},
+		} {
+			_, err := ratesearch.New(c, system, system.counts)
+			Expect(err).To(HaveOccurred())
+		}
+	})
+})
+
+// fakeSystem delivers events up to its capacity. Every call to counts adds
+// one second of traffic at the current rate.
+type fakeSystem struct {
+	capacity float64
+
+	mu       sync.Mutex
+	r        float64
+	sent     int64
+	received int64
+	probes   int
+	calls    int
+}
+
+func (s *fakeSystem) SetRate(r float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.r = r
+}
+
+func (s *fakeSystem) rate() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.r
+}
+
+func (s *fakeSystem) counts() (int64, int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// counts is called at the start and end of every step.
+	s.calls++
+	if s.calls%2 == 0 {
+		s.probes++
+	}
+
+	s.sent += int64(s.r)
+	if s.r <= s.capacity {
+		s.received += int64(s.r)
+	} else {
+		s.received += int64(s.capacity)
+	}
+
+	return s.sent, s.received
+}
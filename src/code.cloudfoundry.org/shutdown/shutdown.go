@@ -0,0 +1,39 @@
+package shutdown
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// Contexts returns two contexts for an orderly shutdown. The emit context
+// is cancelled as soon as the process receives one of sigs, or SIGTERM or
+// SIGINT when none are given, so that emitters stop first. The count context is cancelled once drain has
+// elapsed after that, giving counters time to receive what is still in
+// flight before the final report is flushed.
+func Contexts(drain time.Duration, sigs ...os.Signal) (emit context.Context, count context.Context) {
+	if len(sigs) == 0 {
+		sigs = []os.Signal{syscall.SIGTERM, syscall.SIGINT}
+	}
+
+	received := make(chan os.Signal, 1)
+	signal.Notify(received, sigs...)
+
+	emit, stopEmitting := context.WithCancel(context.Background())
+	count, stopCounting := context.WithCancel(context.Background())
+
+	go func() {
+		sig := <-received
+		log.Printf("Received %s, stopping emitters and draining for %s", sig, drain)
+		stopEmitting()
+
+		time.Sleep(drain)
+		log.Printf("Drain complete, flushing final report")
+		stopCounting()
+	}()
+
+	return emit, count
+}
@@ -0,0 +1,25 @@
+package shutdown_test
+
+import (
+	"syscall"
+	"time"
+
+	"code.cloudfoundry.org/shutdown"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Contexts", func() {
+	It("stops emitters before counters", func() {
+		emit, count := shutdown.Contexts(200*time.Millisecond, syscall.SIGUSR1)
+
+		Consistently(emit.Done()).ShouldNot(BeClosed())
+
+		Expect(syscall.Kill(syscall.Getpid(), syscall.SIGUSR1)).To(Succeed())
+
+		Eventually(emit.Done()).Should(BeClosed())
+		Expect(count.Done()).ToNot(BeClosed())
+		Eventually(count.Done()).Should(BeClosed())
+	})
+})
@@ -1,19 +1,18 @@
 package sysloglistener
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"log"
 	"net"
-	"sync/atomic"
-	"time"
 
 	"code.cloudfoundry.org/datadogreporter"
 	"code.cloudfoundry.org/rfc5424"
 )
 
 type SyslogListener struct {
-	logCount int64
+	logCount datadogreporter.Counter
 	port     string
 }
 
@@ -21,21 +20,28 @@ func New(port string) *SyslogListener {
 	return &SyslogListener{port: port}
 }
 
-func (sl *SyslogListener) Run() {
+func (sl *SyslogListener) Run(ctx context.Context) {
 	l, err := net.Listen("tcp", fmt.Sprintf(":%s", sl.port))
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer l.Close()
 	log.Printf("Listening on %s", sl.port)
 
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+
 	for {
 		conn, err := l.Accept()
-		log.Printf("Accepted connection")
 		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
 			log.Printf("Error accepting: %s", err)
 			continue
 		}
+		log.Printf("Accepted connection")
 
 		go sl.handle(conn)
 	}
@@ -55,20 +61,16 @@ func (sl *SyslogListener) handle(conn net.Conn) {
 			return
 		}
 
-		atomic.AddInt64(&sl.logCount, 1)
+		sl.logCount.Add(1)
 	}
 }
 
-func (sl *SyslogListener) BuildPoints() []datadogreporter.Point {
-	count := atomic.SwapInt64(&sl.logCount, 0)
-
+func (sl *SyslogListener) BuildPoints(w datadogreporter.Window) []datadogreporter.Point {
 	return []datadogreporter.Point{
 		{
 			Metric: "capacity_planning.syslog_drain_received",
-			Points: [][]int64{
-				[]int64{time.Now().Unix(), count},
-			},
-			Type: "gauge",
+			Points: sl.logCount.Samples(w),
+			Type:   "gauge",
 			Tags: []string{
 				"event_type:logs",
 			},
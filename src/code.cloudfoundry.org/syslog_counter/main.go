@@ -4,14 +4,23 @@ import (
 	"flag"
 	"log"
 	"strings"
+	"time"
 
 	"code.cloudfoundry.org/datadogreporter"
+	"code.cloudfoundry.org/shutdown"
 	"code.cloudfoundry.org/syslog_counter/internal/sysloglistener"
 )
 
 func main() {
 	port := flag.String("port", "8080", "port to listen on")
 	datadogAPIKey := flag.String("datadog-api-key", "", "Datadog API key.")
+	sinks := flag.String("sink", "datadog", "Comma separated list of sinks for metrics: datadog, statsd:<addr>, stdout, file:<path> or prometheus:<addr>.")
+	spoolPath := flag.String("datadog-spool-path", "", "File for holding Datadog series that have not been submitted.")
+	datadogSite := flag.String("datadog-site", "", "Datadog site to send metrics to, e.g. datadoghq.eu. Defaults to the US1 site.")
+	datadogAPIVersion := flag.String("datadog-api-version", "v1", "Version of the Datadog series API: v1 or v2.")
+	datadogCompress := flag.Bool("datadog-compress", false, "Gzip requests to Datadog.")
+	drainTimeout := flag.Duration("drain-timeout", 5*time.Second, "Time to keep counting after emitters stop on shutdown.")
+	resolution := flag.String("report-resolution", "interval", "How per-second counts are reported: interval, second or summary.")
 
 	jobName := flag.String("job-name", "", "Name of the bosh job")
 	instanceID := flag.String("instance-id", "", "Bosh job instance ID")
@@ -23,10 +32,6 @@ func main() {
 		missing = append(missing, "port")
 	}
 
-	if *datadogAPIKey == "" {
-		missing = append(missing, "datadog-api-key")
-	}
-
 	if *jobName == "" {
 		missing = append(missing, "job-name")
 	}
@@ -39,14 +44,30 @@ func main() {
 		log.Fatalf("missing required flags: %s", strings.Join(missing, ", "))
 	}
 
+	sink, err := datadogreporter.NewSink(datadogreporter.SinkConfig{
+		Sinks:      *sinks,
+		APIKey:     *datadogAPIKey,
+		SpoolPath:  *spoolPath,
+		Site:       *datadogSite,
+		APIVersion: *datadogAPIVersion,
+		Compress:   *datadogCompress,
+	})
+	if err != nil {
+		log.Fatalf("failed to create metrics sink: %s", err)
+	}
+
+	_, ctx := shutdown.Contexts(*drainTimeout)
+
 	lis := sysloglistener.New(*port)
-	go lis.Run()
+	go lis.Run(ctx)
 
 	reporter := datadogreporter.New(
 		*datadogAPIKey,
 		*jobName,
 		*instanceID,
 		lis,
+		datadogreporter.WithSink(sink),
+		datadogreporter.WithResolution(*resolution),
 	)
-	reporter.Run()
+	reporter.Run(ctx)
 }
@@ -0,0 +1,113 @@
+// Package tlsclient builds TLS configurations for clients that connect to
+// UAA, the Cloud Controller and Loggregator.
+package tlsclient
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// Config describes how a client verifies servers and identifies itself.
+type Config struct {
+	// CAPath is a PEM file of certificate authorities trusted to sign
+	// server certificates. The system pool is used when it is empty.
+	CAPath string
+
+	// CertPath and KeyPath are a client certificate and private key
+	// presented to servers that request one. Both or neither must be set.
+	CertPath string
+	KeyPath  string
+
+	// Insecure disables verification of server certificates. It should
+	// only be set at the explicit request of an operator.
+	Insecure bool
+
+	// UAAServerName, APIServerName and LoggregatorServerName override the
+	// name that the certificates of UAA, the CF API and Loggregator's
+	// egress (doppler, the RLP gateway and Log Cache) are verified against.
+	// Each one only applies to the config for its own endpoint.
+	UAAServerName         string
+	APIServerName         string
+	LoggregatorServerName string
+}
+
+// Configs holds a tls.Config for each endpoint that clients connect to.
+type Configs struct {
+	UAA         *tls.Config
+	API         *tls.Config
+	Loggregator *tls.Config
+}
+
+// NewConfigs builds a tls.Config from c for each endpoint, with that
+// endpoint's server name override.
+func NewConfigs(c Config) (Configs, error) {
+	base, err := New(c)
+	if err != nil {
+		return Configs{}, err
+	}
+
+	return Configs{
+		UAA:         withServerName(base, c.UAAServerName),
+		API:         withServerName(base, c.APIServerName),
+		Loggregator: withServerName(base, c.LoggregatorServerName),
+	}, nil
+}
+
+// New builds a tls.Config from c. Server name overrides are not applied;
+// use NewConfigs for those.
+func New(c Config) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: c.Insecure,
+	}
+
+	if c.CAPath != "" {
+		pem, err := ioutil.ReadFile(c.CAPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %s", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA file %s", c.CAPath)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if (c.CertPath == "") != (c.KeyPath == "") {
+		return nil, errors.New("client certificate and key must be given together")
+	}
+
+	if c.CertPath != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertPath, c.KeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %s", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// withServerName returns a copy of tlsConfig that verifies server
+// certificates against name, or the host name if name is empty.
+func withServerName(tlsConfig *tls.Config, name string) *tls.Config {
+	tlsConfig = tlsConfig.Clone()
+	tlsConfig.ServerName = name
+
+	return tlsConfig
+}
+
+// HTTPClient returns an HTTP client that connects with tlsConfig.
+func HTTPClient(tlsConfig *tls.Config) *http.Client {
+	return &http.Client{
+		Timeout: 30 * time.Second,
+		Transport: &http.Transport{
+			TLSClientConfig: tlsConfig,
+		},
+	}
+}
@@ -0,0 +1,143 @@
+package tlsclient_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+
+	"code.cloudfoundry.org/tlsclient"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("TLSClient", func() {
+	var (
+		dir      string
+		certPath string
+		keyPath  string
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "tlsclient")
+		Expect(err).ToNot(HaveOccurred())
+
+		certPath, keyPath = writeCert(dir)
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("verifies servers against the system pool by default", func() {
+		tlsConfig, err := tlsclient.New(tlsclient.Config{})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(tlsConfig.InsecureSkipVerify).To(BeFalse())
+		Expect(tlsConfig.RootCAs).To(BeNil())
+		Expect(tlsConfig.Certificates).To(BeEmpty())
+	})
+
+	It("trusts the CAs in the CA file", func() {
+		tlsConfig, err := tlsclient.New(tlsclient.Config{
+			CAPath: certPath,
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(tlsConfig.RootCAs.Subjects()).To(HaveLen(1))
+	})
+
+	It("loads the client certificate", func() {
+		tlsConfig, err := tlsclient.New(tlsclient.Config{
+			CertPath: certPath,
+			KeyPath:  keyPath,
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(tlsConfig.Certificates).To(HaveLen(1))
+	})
+
+	It("skips verification only when asked to", func() {
+		tlsConfig, err := tlsclient.New(tlsclient.Config{Insecure: true})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(tlsConfig.InsecureSkipVerify).To(BeTrue())
+	})
+
+	It("applies each server name override to its own endpoint only", func() {
+		configs, err := tlsclient.NewConfigs(tlsclient.Config{
+			CAPath:                certPath,
+			UAAServerName:         "uaa.example.com",
+			LoggregatorServerName: "doppler.example.com",
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(configs.UAA.ServerName).To(Equal("uaa.example.com"))
+		Expect(configs.API.ServerName).To(BeEmpty())
+		Expect(configs.Loggregator.ServerName).To(Equal("doppler.example.com"))
+
+		for _, c := range []*tls.Config{configs.UAA, configs.API, configs.Loggregator} {
+			Expect(c.RootCAs.Subjects()).To(HaveLen(1))
+		}
+	})
+
+	It("returns an error for endpoint configs from an invalid config", func() {
+		_, err := tlsclient.NewConfigs(tlsclient.Config{CertPath: certPath})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("returns an error for a CA file without certificates", func() {
+		_, err := tlsclient.New(tlsclient.Config{CAPath: keyPath})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("returns an error for a missing CA file", func() {
+		_, err := tlsclient.New(tlsclient.Config{CAPath: filepath.Join(dir, "missing")})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("returns an error for a certificate without a key", func() {
+		_, err := tlsclient.New(tlsclient.Config{CertPath: certPath})
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+func writeCert(dir string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).ToNot(HaveOccurred())
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	Expect(err).ToNot(HaveOccurred())
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	Expect(err).ToNot(HaveOccurred())
+
+	certPath := filepath.Join(dir, "cert.pem")
+	keyPath := filepath.Join(dir, "key.pem")
+
+	err = ioutil.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	Expect(err).ToNot(HaveOccurred())
+	err = ioutil.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	Expect(err).ToNot(HaveOccurred())
+
+	return certPath, keyPath
+}
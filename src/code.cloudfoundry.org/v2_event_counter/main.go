@@ -6,21 +6,29 @@ import (
 	"fmt"
 	"log"
 	"os"
-	"sync/atomic"
 	"time"
 
 	"code.cloudfoundry.org/datadogreporter"
 	envstruct "code.cloudfoundry.org/go-envstruct"
 	loggregator "code.cloudfoundry.org/go-loggregator"
 	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"code.cloudfoundry.org/shutdown"
 )
 
 type Config struct {
-	EventTitle    string `env:"EVENT_TITLE"`
-	DatadogAPIKey string `env:"DATADOG_API_KEY, required"`
-	JobName       string `env:"JOB_NAME,        required"`
-	InstanceID    string `env:"INSTANCE_ID,     required"`
-	Host          string `env:"HOST,            required"`
+	EventTitle    string        `env:"EVENT_TITLE"`
+	DrainTimeout  time.Duration `env:"DRAIN_TIMEOUT"`
+	Resolution    string        `env:"REPORT_RESOLUTION"`
+	DatadogAPIKey string        `env:"DATADOG_API_KEY"`
+	Sinks         string        `env:"SINK"`
+	SpoolPath     string        `env:"DATADOG_SPOOL_PATH"`
+
+	DatadogSite       string `env:"DATADOG_SITE"`
+	DatadogAPIVersion string `env:"DATADOG_API_VERSION"`
+	DatadogCompress   bool   `env:"DATADOG_COMPRESS"`
+	JobName           string `env:"JOB_NAME,        required"`
+	InstanceID        string `env:"INSTANCE_ID,     required"`
+	Host              string `env:"HOST,            required"`
 
 	CAPath   string `env:"CA_PATH,   required"`
 	KeyPath  string `env:"KEY_PATH,  required"`
@@ -30,7 +38,11 @@ type Config struct {
 }
 
 func main() {
-	var cfg Config
+	cfg := Config{
+		Sinks:        "datadog",
+		DrainTimeout: 5 * time.Second,
+		Resolution:   datadogreporter.ResolutionInterval,
+	}
 	err := envstruct.Load(&cfg)
 	if err != nil {
 		log.Fatalf("failed to load config %s", err)
@@ -45,8 +57,21 @@ func main() {
 		log.Fatalf("failed to create tls config %s", err)
 	}
 
+	sink, err := datadogreporter.NewSink(datadogreporter.SinkConfig{
+		Sinks:      cfg.Sinks,
+		APIKey:     cfg.DatadogAPIKey,
+		SpoolPath:  cfg.SpoolPath,
+		Site:       cfg.DatadogSite,
+		APIVersion: cfg.DatadogAPIVersion,
+		Compress:   cfg.DatadogCompress,
+	})
+	if err != nil {
+		log.Fatalf("failed to create metrics sink: %s", err)
+	}
+
 	reader := newReader(cfg.EventTitle, cfg.LogProxyAddr, tlsConfig)
-	go reader.run()
+	_, ctx := shutdown.Contexts(cfg.DrainTimeout)
+	go reader.run(ctx)
 
 	reporter := datadogreporter.New(
 		cfg.DatadogAPIKey,
@@ -54,15 +79,17 @@ func main() {
 		cfg.InstanceID,
 		reader,
 		datadogreporter.WithHost(cfg.Host),
+		datadogreporter.WithSink(sink),
+		datadogreporter.WithResolution(cfg.Resolution),
 	)
 
-	reporter.Run()
+	reporter.Run(ctx)
 }
 
 type reader struct {
 	title      string
 	client     *loggregator.EnvelopeStreamConnector
-	eventCount int64
+	eventCount datadogreporter.Counter
 }
 
 func newReader(title string, logProxyAddr string, tlsConfig *tls.Config) *reader {
@@ -74,8 +101,8 @@ func newReader(title string, logProxyAddr string, tlsConfig *tls.Config) *reader
 	}
 }
 
-func (r *reader) run() {
-	stream := r.client.Stream(context.Background(), &loggregator_v2.EgressBatchRequest{
+func (r *reader) run(ctx context.Context) {
+	stream := r.client.Stream(ctx, &loggregator_v2.EgressBatchRequest{
 		ShardId:          fmt.Sprintf("%d", time.Now().UnixNano()),
 		UsePreferredTags: true,
 		Selectors: []*loggregator_v2.Selector{
@@ -87,26 +114,22 @@ func (r *reader) run() {
 		},
 	})
 
-	for {
+	for ctx.Err() == nil {
 		envelopes := stream()
 		for _, env := range envelopes {
 			if env.GetEvent().GetTitle() == r.title {
-				atomic.AddInt64(&r.eventCount, 1)
+				r.eventCount.Add(1)
 			}
 		}
 	}
 }
 
-func (r *reader) BuildPoints() []datadogreporter.Point {
-	count := atomic.SwapInt64(&r.eventCount, 0)
-
+func (r *reader) BuildPoints(w datadogreporter.Window) []datadogreporter.Point {
 	return []datadogreporter.Point{
 		{
 			Metric: "v2_event_counter.read",
-			Points: [][]int64{
-				[]int64{time.Now().Unix(), count},
-			},
-			Type: "guage",
+			Points: r.eventCount.Samples(w),
+			Type:   "guage",
 		},
 	}
 }